@@ -0,0 +1,109 @@
+package logstash
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	beatsVersion = '2'
+
+	beatsFrameWindow     = 'W'
+	beatsFrameJSON       = 'J'
+	beatsFrameCompressed = 'C'
+	beatsFrameAck        = 'A'
+
+	defaultBeatsWindowSize       = 1024
+	defaultBeatsCompressionLevel = 3
+	defaultBeatsTimeout          = 30 * time.Second
+)
+
+// beatsProtocol speaks the Lumberjack v2 protocol of the Logstash beats
+// input. Every batch is sent as a window of JSON frames, optionally wrapped
+// in a compressed frame, and is only delivered once Logstash acknowledged
+// the last frame of the window.
+type beatsProtocol struct {
+	windowSize       int
+	compressionLevel int
+	timeout          time.Duration
+}
+
+func (p *beatsProtocol) writeBatch(conn net.Conn, events [][]byte) error {
+	for len(events) > 0 {
+		n := len(events)
+		if n > p.windowSize {
+			n = p.windowSize
+		}
+		if err := p.writeWindow(conn, events[:n]); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+// writeWindow sends events as one window, numbered from 1, and waits for
+// the acknowledgement of the last one.
+func (p *beatsProtocol) writeWindow(conn net.Conn, events [][]byte) error {
+	var frames bytes.Buffer
+	for i, js := range events {
+		frames.Write([]byte{beatsVersion, beatsFrameJSON})
+		binary.Write(&frames, binary.BigEndian, uint32(i+1))
+		binary.Write(&frames, binary.BigEndian, uint32(len(js)))
+		frames.Write(js)
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{beatsVersion, beatsFrameWindow})
+	binary.Write(&buf, binary.BigEndian, uint32(len(events)))
+
+	if p.compressionLevel > zlib.NoCompression {
+		var compressed bytes.Buffer
+		w, err := zlib.NewWriterLevel(&compressed, p.compressionLevel)
+		if err != nil {
+			return err
+		}
+		w.Write(frames.Bytes())
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		buf.Write([]byte{beatsVersion, beatsFrameCompressed})
+		binary.Write(&buf, binary.BigEndian, uint32(compressed.Len()))
+		buf.Write(compressed.Bytes())
+	} else {
+		buf.Write(frames.Bytes())
+	}
+
+	if p.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	// Logstash may acknowledge part of the window while it is still busy,
+	// only the last sequence number means everything was received.
+	ack := make([]byte, 6)
+	for {
+		if _, err := io.ReadFull(conn, ack); err != nil {
+			return err
+		}
+		if ack[0] != beatsVersion || ack[1] != beatsFrameAck {
+			return fmt.Errorf("logstash: unexpected beats frame %q", ack[:2])
+		}
+		if seq := binary.BigEndian.Uint32(ack[2:]); seq >= uint32(len(events)) {
+			return nil
+		}
+		if p.timeout > 0 {
+			conn.SetDeadline(time.Now().Add(p.timeout))
+		}
+	}
+}
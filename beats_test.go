@@ -0,0 +1,169 @@
+package logstash
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type TCPTransport struct{}
+
+func (t TCPTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	return net.Dial("tcp", addr)
+}
+
+// readBeatsWindow reads a window sent by a beats client, the way the beats
+// input does.
+func readBeatsWindow(r io.Reader) ([]string, error) {
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	count := int(binary.BigEndian.Uint32(header[2:]))
+
+	var events []string
+	for len(events) < count {
+		frame := make([]byte, 2)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+
+		switch frame[1] {
+		case beatsFrameCompressed:
+			var length uint32
+			binary.Read(r, binary.BigEndian, &length)
+			compressed := make([]byte, length)
+			if _, err := io.ReadFull(r, compressed); err != nil {
+				return nil, err
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				return nil, err
+			}
+			frames, _ := ioutil.ReadAll(zr)
+			r = io.MultiReader(bytes.NewReader(frames), r)
+		case beatsFrameJSON:
+			var seq, length uint32
+			binary.Read(r, binary.BigEndian, &seq)
+			binary.Read(r, binary.BigEndian, &length)
+			js := make([]byte, length)
+			if _, err := io.ReadFull(r, js); err != nil {
+				return nil, err
+			}
+			if int(seq) != len(events)+1 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			var data map[string]interface{}
+			if err := json.Unmarshal(js, &data); err != nil {
+				return nil, err
+			}
+			events = append(events, data["message"].(string))
+		}
+	}
+	return events, nil
+}
+
+func writeBeatsAck(w io.Writer, seq int) {
+	ack := []byte{beatsVersion, beatsFrameAck, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(ack[2:], uint32(seq))
+	w.Write(ack)
+}
+
+func beatsEvents(messages ...string) [][]byte {
+	var events [][]byte
+	for _, m := range messages {
+		js, _ := json.Marshal(map[string]string{"message": m})
+		events = append(events, js)
+	}
+	return events
+}
+
+func TestBeatsProtocolWindows(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, level := range []int{zlib.NoCompression, zlib.BestSpeed} {
+		client, server := net.Pipe()
+		windows := make(chan []string, 2)
+
+		go func() {
+			for i := 0; i < 2; i++ {
+				events, err := readBeatsWindow(server)
+				if err != nil {
+					return
+				}
+				// A partial acknowledgement first, like a busy Logstash.
+				writeBeatsAck(server, len(events)-1)
+				writeBeatsAck(server, len(events))
+				windows <- events
+			}
+		}()
+
+		p := &beatsProtocol{windowSize: 2, compressionLevel: level, timeout: time.Second}
+		err := p.writeBatch(client, beatsEvents("1", "2", "3"))
+		assert.Nil(err)
+
+		assert.Equal([]string{"1", "2"}, <-windows)
+		assert.Equal([]string{"3"}, <-windows)
+		client.Close()
+	}
+}
+
+func TestBeatsProtocolTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go readBeatsWindow(server)
+
+	p := &beatsProtocol{windowSize: 2, compressionLevel: 3, timeout: 10 * time.Millisecond}
+	err := p.writeBatch(client, beatsEvents("1"))
+	assert.NotNil(err)
+}
+
+func TestBeatsRetransmitsUnacknowledgedWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+
+	received := make(chan []string, 2)
+	go func() {
+		// The first connection dies before acknowledging the window.
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		events, _ := readBeatsWindow(conn)
+		received <- events
+		conn.Close()
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		events, _ = readBeatsWindow(conn)
+		writeBeatsAck(conn, len(events))
+		received <- events
+	}()
+
+	adapter := newTestAdapter(nil)
+	adapter.endpoints[0].address = listener.Addr().String()
+	adapter.transport = TCPTransport{}
+	adapter.endpoints[0].backoff = backoff{min: time.Millisecond, max: time.Millisecond}
+	adapter.protocol = &beatsProtocol{windowSize: 4, compressionLevel: 3, timeout: time.Second}
+
+	adapter.write(beatsEvents("1", "2", "3"))
+
+	assert.Equal([]string{"1", "2", "3"}, <-received)
+	assert.Equal([]string{"1", "2", "3"}, <-received)
+}
@@ -0,0 +1,119 @@
+package logstash
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultContainerCacheSize = 4096
+	defaultContainerCacheTTL  = time.Hour
+)
+
+// containerCache holds settings computed per container, such as its tags. It
+// is safe for concurrent use. Containers unused for longer than the TTL are
+// evicted, as are the least recently used containers beyond the capacity, so
+// the cache doesn't grow with every short-lived container.
+type containerCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+type containerEntry struct {
+	id       string
+	used     time.Time
+	settings map[string]interface{}
+}
+
+func newContainerCache(capacity int, ttl time.Duration) *containerCache {
+	return &containerCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns the setting name of container id, if it is cached.
+func (c *containerCache) get(id, name string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	entry := el.Value.(*containerEntry)
+	if c.ttl > 0 && time.Since(entry.used) > c.ttl {
+		c.remove(el)
+		return nil, false
+	}
+
+	value, ok := entry.settings[name]
+	if ok {
+		entry.used = time.Now()
+		c.lru.MoveToFront(el)
+	}
+	return value, ok
+}
+
+// set caches the setting name of container id, evicting expired containers
+// and the least recently used ones if the cache is full.
+func (c *containerCache) set(id, name string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		el = c.lru.PushFront(&containerEntry{id: id, settings: make(map[string]interface{})})
+		c.entries[id] = el
+	}
+
+	entry := el.Value.(*containerEntry)
+	entry.settings[name] = value
+	entry.used = time.Now()
+	c.lru.MoveToFront(el)
+
+	for oldest := c.lru.Back(); oldest != nil && oldest != el; oldest = c.lru.Back() {
+		expired := c.ttl > 0 && time.Since(oldest.Value.(*containerEntry).used) > c.ttl
+		if !expired && c.lru.Len() <= c.capacity {
+			break
+		}
+		c.remove(oldest)
+	}
+}
+
+func (c *containerCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*containerEntry).id)
+}
+
+// each calls fn with the setting name of every cached container that has it.
+// fn must not use the cache.
+func (c *containerCache) each(name string, fn func(id string, value interface{})) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*containerEntry)
+		if c.ttl > 0 && time.Since(entry.used) > c.ttl {
+			continue
+		}
+		if value, ok := entry.settings[name]; ok {
+			fn(entry.id, value)
+		}
+	}
+}
+
+// len returns the number of cached containers.
+func (c *containerCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
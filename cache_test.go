@@ -0,0 +1,74 @@
+package logstash
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newContainerCache(2, time.Hour)
+	c.set("a", "tags", 1)
+	c.set("b", "tags", 2)
+
+	_, ok := c.get("a", "tags")
+	assert.True(ok)
+
+	c.set("c", "tags", 3)
+	assert.Equal(2, c.len())
+
+	_, ok = c.get("b", "tags")
+	assert.False(ok)
+	v, ok := c.get("a", "tags")
+	assert.True(ok)
+	assert.Equal(1, v)
+}
+
+func TestContainerCacheEvictsExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newContainerCache(10, 20*time.Millisecond)
+	c.set("a", "tags", 1)
+	c.set("b", "tags", 2)
+	time.Sleep(40 * time.Millisecond)
+
+	_, ok := c.get("a", "tags")
+	assert.False(ok)
+	assert.Equal(1, c.len())
+
+	c.set("c", "tags", 3)
+	assert.Equal(1, c.len())
+}
+
+func TestContainerCacheConcurrentAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	adapter.containers = newContainerCache(8, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				container := docker.Container{
+					ID:     strconv.Itoa(i*100 + j),
+					Config: &docker.Config{Env: []string{"LOGSTASH_TAGS=a,b", "LOGSTASH_FIELDS=k=v"}},
+				}
+				assert.Equal([]string{"a", "b"}, GetContainerTags(&container, adapter))
+				assert.Equal("v", GetLogstashFields(&container, adapter)["k"])
+				assert.True(IsDecodeJsonLogs(&container, adapter))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(8, adapter.containers.len())
+}
@@ -0,0 +1,100 @@
+package logstash
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZlib = "zlib"
+	compressionZstd = "zstd"
+
+	// defaultCompressionLevel picks the default level of the algorithm.
+	defaultCompressionLevel = -1
+	maxZstdLevel            = 22
+)
+
+// compressor is a streaming compressor, such as a gzip.Writer.
+type compressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// newCompressor returns a compressor writing to w with the compression and
+// level of a route.
+func newCompressor(w io.Writer, compression string, level int) (compressor, error) {
+	switch compression {
+	case compressionZlib:
+		return zlib.NewWriterLevel(w, level)
+	case compressionZstd:
+		zstdLevel := zstd.SpeedDefault
+		if level != defaultCompressionLevel {
+			zstdLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderConcurrency(1))
+	default:
+		return gzip.NewWriterLevel(w, level)
+	}
+}
+
+// compressedConn compresses everything written to a connection as a single
+// stream. Written data is buffered by the compressor until it is flushed.
+type compressedConn struct {
+	net.Conn
+	w compressor
+}
+
+func (c *compressedConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+// Flush writes the data compressed so far to the connection, so that it can
+// be decompressed up to that point.
+func (c *compressedConn) Flush() error {
+	return c.w.Flush()
+}
+
+// Close ends the compressed stream and closes the connection.
+func (c *compressedConn) Close() error {
+	c.w.Close()
+	return c.Conn.Close()
+}
+
+// compressedTransport wraps the connections of a transport in a
+// compressedConn.
+type compressedTransport struct {
+	router.AdapterTransport
+	compression string
+	level       int
+}
+
+func (t *compressedTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	conn, err := t.AdapterTransport.Dial(addr, options)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := newCompressor(conn, t.compression, t.level)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &compressedConn{Conn: conn, w: w}, nil
+}
+
+// flushBatch flushes the compressor of conn, if it has one, at the end of a
+// batch.
+func flushBatch(conn net.Conn) error {
+	if c, ok := conn.(interface{ Flush() error }); ok {
+		return c.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package logstash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDecompressor(compression string, r io.Reader) (io.Reader, error) {
+	switch compression {
+	case compressionZlib:
+		return zlib.NewReader(r)
+	case compressionZstd:
+		return zstd.NewReader(r)
+	default:
+		return gzip.NewReader(r)
+	}
+}
+
+func TestCompressedConnFlushesBatches(t *testing.T) {
+	for _, compression := range []string{compressionGzip, compressionZlib, compressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var writes []string
+			var closed int32
+			adapter := newTestAdapter(nil)
+			adapter.transport = &compressedTransport{
+				AdapterTransport: &MockTransport{conns: []net.Conn{ClosingConn{RecordingConn{writes: &writes}, &closed}}},
+				compression:      compression,
+				level:            defaultCompressionLevel,
+			}
+			assert.Nil(adapter.connect())
+
+			// Every batch can be decompressed as soon as it is written.
+			assert.True(adapter.tryWrite([][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`)}))
+			r, err := newDecompressor(compression, strings.NewReader(strings.Join(writes, "")))
+			assert.Nil(err)
+			buf := make([]byte, len("{\"a\":1}\n{\"b\":2}\n"))
+			_, err = io.ReadFull(r, buf)
+			assert.Nil(err)
+			assert.Equal("{\"a\":1}\n{\"b\":2}\n", string(buf))
+
+			assert.True(adapter.tryWrite([][]byte{[]byte(`{"c":3}`)}))
+			adapter.closeConns()
+			assert.Equal(int32(1), closed)
+
+			// Closing ends the stream.
+			r, err = newDecompressor(compression, strings.NewReader(strings.Join(writes, "")))
+			assert.Nil(err)
+			content, err := ioutil.ReadAll(r)
+			assert.Nil(err)
+			assert.Equal("{\"a\":1}\n{\"b\":2}\n{\"c\":3}\n", string(content))
+		})
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	payload := bytes.Repeat([]byte(`{"message":"hello world","level":"info"}`+"\n"), 1000)
+	size := func(compression string, level int) int {
+		var buf bytes.Buffer
+		w, err := newCompressor(&buf, compression, level)
+		assert.Nil(err)
+		w.Write(payload)
+		w.Close()
+		return buf.Len()
+	}
+
+	assert.True(size(compressionGzip, 0) > len(payload))
+	assert.True(size(compressionGzip, 9) < len(payload)/10)
+	assert.True(size(compressionZstd, 19) < len(payload)/10)
+}
+
+func TestCompressionSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	config, err := newRouteConfig(&router.Route{Options: map[string]string{"compression": "zstd", "compression_level": "19"}})
+	assert.Nil(err)
+	assert.Equal(compressionZstd, config.compression)
+	assert.Equal(19, config.compressionLevel)
+
+	_, err = newRouteConfig(&router.Route{Options: map[string]string{"compression": "gzip", "compression_level": "19"}})
+	assert.NotNil(err)
+	_, err = newRouteConfig(&router.Route{Options: map[string]string{"compression": "lz4"}})
+	assert.NotNil(err)
+
+	_, err = NewLogstashAdapter(&router.Route{Adapter: "logstash+udp", Options: map[string]string{"compression": "gzip"}})
+	assert.NotNil(err)
+	_, err = NewLogstashAdapter(&router.Route{Adapter: "logstash+beats", Options: map[string]string{"compression": "gzip"}})
+	assert.NotNil(err)
+}
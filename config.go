@@ -0,0 +1,316 @@
+package logstash
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// routeConfig holds the settings of a route. Every setting is read from the
+// route options, the query string of the route URI, using the lowercase name
+// of its environment variable, and else from the environment variable itself.
+type routeConfig struct {
+	retryStartup bool
+	backoffMin   time.Duration
+	backoffMax   time.Duration
+
+	endpoints        []string
+	endpointStrategy string
+	endpointCooldown time.Duration
+	dnsTTL           time.Duration
+
+	tlsCAFile     string
+	tlsCertFile   string
+	tlsKeyFile    string
+	tlsServerName string
+	tlsMinVersion string
+	tlsPins       [][]byte
+
+	queueSize     int
+	queueOverflow string
+
+	batchMaxEvents int
+	batchMaxBytes  int
+	batchLinger    time.Duration
+	udpMaxPayload  int
+	udpOversize    string
+
+	compression      string
+	compressionLevel int
+
+	spoolDir      string
+	spoolMaxBytes int64
+	spoolMaxAge   time.Duration
+
+	beatsWindowSize       int
+	beatsCompressionLevel int
+	beatsTimeout          time.Duration
+
+	containerCacheSize int
+	containerCacheTTL  time.Duration
+
+	metricsAddr     string
+	shutdownTimeout time.Duration
+
+	hostHostnameFile   string
+	hostHostnameReload time.Duration
+
+	includeContainers      []string
+	includeContainersRegex *regexp.Regexp
+	includeLabels          []labelFilter
+	excludeContainers      []string
+	excludeContainersRegex *regexp.Regexp
+	excludeImages          []string
+	excludeImagesRegex     *regexp.Regexp
+	excludeLabels          []labelFilter
+
+	encoder         string
+	gelfCompression string
+	gelfChunkSize   int
+
+	outputSchema       string
+	dockerLabels       bool
+	dockerLabelsNested bool
+	timestampField     string
+	timestampPrecision string
+	keepJSONTimestamp  bool
+
+	// Defaults for the settings that can also be set per container.
+	logstashTags   string
+	logstashFields string
+	decodeJsonLogs string
+	decodeLogfmt   string
+
+	logstashFieldsTyped bool
+	multiline           map[string]string
+}
+
+// tlsConfigured returns true if any of the TLS settings is set.
+func (c *routeConfig) tlsConfigured() bool {
+	return c.tlsCAFile != "" || c.tlsCertFile != "" || c.tlsKeyFile != "" ||
+		c.tlsServerName != "" || c.tlsMinVersion != "" || len(c.tlsPins) > 0
+}
+
+// settingsReader reads the settings of a route, collecting every invalid
+// value instead of stopping at the first one.
+type settingsReader struct {
+	options map[string]string
+	invalid []string
+}
+
+func (r *settingsReader) get(name string) string {
+	if value, ok := r.options[strings.ToLower(name)]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+func (r *settingsReader) fail(name, value string) {
+	r.invalid = append(r.invalid, name+"="+value)
+}
+
+func (r *settingsReader) int(name string, dflt, min, max int) int {
+	s := r.get(name)
+	if s == "" {
+		return dflt
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		r.fail(name, s)
+		return dflt
+	}
+	return n
+}
+
+func (r *settingsReader) duration(name string, dflt time.Duration) time.Duration {
+	s := r.get(name)
+	if s == "" {
+		return dflt
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		r.fail(name, s)
+		return dflt
+	}
+	return d
+}
+
+func (r *settingsReader) oneOf(name, dflt string, values ...string) string {
+	s := r.get(name)
+	if s == "" {
+		return dflt
+	}
+	for _, v := range values {
+		if s == v {
+			return s
+		}
+	}
+	r.fail(name, s)
+	return dflt
+}
+
+func (r *settingsReader) regexp(name string) *regexp.Regexp {
+	s := r.get(name)
+	if s == "" {
+		return nil
+	}
+	re, err := regexp.Compile(s)
+	if err != nil {
+		r.fail(name, s)
+	}
+	return re
+}
+
+func (r *settingsReader) list(name string) []string {
+	if s := r.get(name); s != "" {
+		return strings.Split(s, ",")
+	}
+	return nil
+}
+
+func (r *settingsReader) labelFilters(name string) []labelFilter {
+	var filters []labelFilter
+	for _, s := range r.list(name) {
+		f, err := parseLabelFilter(s)
+		if err != nil {
+			r.fail(name, s)
+			continue
+		}
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+func (r *settingsReader) pins(name string) [][]byte {
+	var pins [][]byte
+	for _, s := range r.list(name) {
+		pin, err := parsePin(s)
+		if err != nil {
+			r.fail(name, s)
+			continue
+		}
+		pins = append(pins, pin)
+	}
+	return pins
+}
+
+// newRouteConfig reads and validates every setting of route.
+func newRouteConfig(route *router.Route) (*routeConfig, error) {
+	r := &settingsReader{options: route.Options}
+	const maxInt = int(^uint(0) >> 1)
+
+	c := &routeConfig{
+		retryStartup: r.get("RETRY_STARTUP") != "",
+		backoffMin:   r.duration("RECONNECT_BACKOFF_MIN", defaultBackoffMin),
+		backoffMax:   r.duration("RECONNECT_BACKOFF_MAX", defaultBackoffMax),
+
+		endpoints:        r.list("ENDPOINTS"),
+		endpointStrategy: r.oneOf("ENDPOINT_STRATEGY", strategyFailover, strategyFailover, strategyRoundRobin, strategyLeastLoaded),
+		endpointCooldown: r.duration("ENDPOINT_COOLDOWN", defaultEndpointCooldown),
+		dnsTTL:           r.duration("DNS_TTL", defaultDNSTTL),
+
+		tlsCAFile:     r.get("TLS_CA_FILE"),
+		tlsCertFile:   r.get("TLS_CERT_FILE"),
+		tlsKeyFile:    r.get("TLS_KEY_FILE"),
+		tlsServerName: r.get("TLS_SERVER_NAME"),
+		tlsMinVersion: r.oneOf("TLS_MIN_VERSION", "", "1.0", "1.1", "1.2", "1.3"),
+		tlsPins:       r.pins("TLS_PINS"),
+
+		queueSize:     r.int("QUEUE_SIZE", defaultQueueSize, 1, maxInt),
+		queueOverflow: r.oneOf("QUEUE_OVERFLOW", overflowBlock, overflowBlock, overflowDropNewest, overflowDropOldest),
+
+		batchMaxEvents: r.int("BATCH_MAX_EVENTS", defaultBatchMaxEvents, 1, maxInt),
+		batchMaxBytes:  r.int("BATCH_MAX_BYTES", defaultBatchMaxBytes, 1, maxInt),
+		batchLinger:    r.duration("BATCH_LINGER", 0),
+		udpMaxPayload:  r.int("UDP_MAX_PAYLOAD", defaultUDPMaxPayload, 1, maxUDPPayload),
+		udpOversize:    r.oneOf("UDP_OVERSIZE", oversizeTruncate, oversizeTruncate, oversizeSplit, oversizeDrop),
+
+		compression:      r.oneOf("COMPRESSION", compressionNone, compressionNone, compressionGzip, compressionZlib, compressionZstd),
+		compressionLevel: r.int("COMPRESSION_LEVEL", defaultCompressionLevel, defaultCompressionLevel, maxZstdLevel),
+
+		spoolDir:      r.get("SPOOL_DIR"),
+		spoolMaxBytes: int64(r.int("SPOOL_MAX_BYTES", defaultSpoolMaxBytes, spoolHeaderSize, maxInt)),
+		spoolMaxAge:   r.duration("SPOOL_MAX_AGE", defaultSpoolMaxAge),
+
+		beatsWindowSize:       r.int("BEATS_WINDOW_SIZE", defaultBeatsWindowSize, 1, maxInt),
+		beatsCompressionLevel: r.int("BEATS_COMPRESSION_LEVEL", defaultBeatsCompressionLevel, 0, 9),
+		beatsTimeout:          r.duration("BEATS_TIMEOUT", defaultBeatsTimeout),
+
+		containerCacheSize: r.int("CONTAINER_CACHE_SIZE", defaultContainerCacheSize, 1, maxInt),
+		containerCacheTTL:  r.duration("CONTAINER_CACHE_TTL", defaultContainerCacheTTL),
+
+		metricsAddr:     r.get("METRICS_ADDR"),
+		shutdownTimeout: r.duration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout),
+
+		hostHostnameFile:   r.get("HOST_HOSTNAME_FILE"),
+		hostHostnameReload: r.duration("HOST_HOSTNAME_RELOAD", defaultHostHostnameReload),
+
+		includeContainers:      r.list("INCLUDE_CONTAINERS"),
+		includeContainersRegex: r.regexp("INCLUDE_CONTAINERS_REGEX"),
+		includeLabels:          r.labelFilters("INCLUDE_LABELS"),
+		excludeContainers:      r.list("EXCLUDE_CONTAINERS"),
+		excludeContainersRegex: r.regexp("EXCLUDE_CONTAINERS_REGEX"),
+		excludeImages:          r.list("EXCLUDE_IMAGES"),
+		excludeImagesRegex:     r.regexp("EXCLUDE_IMAGES_REGEX"),
+		excludeLabels:          r.labelFilters("EXCLUDE_LABELS"),
+
+		encoder:         r.oneOf("ENCODER", encoderJSONLines, encoderJSONLines, encoderGELF),
+		gelfCompression: r.oneOf("GELF_COMPRESSION", gelfCompressionGzip, gelfCompressionGzip, gelfCompressionZlib, gelfCompressionNone),
+		gelfChunkSize:   r.int("GELF_CHUNK_SIZE", defaultGELFChunkSize, gelfChunkHeaderSize+1, 65507),
+
+		outputSchema:       r.oneOf("OUTPUT_SCHEMA", schemaLogstash, schemaLogstash, schemaECS),
+		dockerLabels:       r.get("DOCKER_LABELS") != "",
+		dockerLabelsNested: r.get("DOCKER_LABELS") == "nested",
+		timestampField:     r.get("TIMESTAMP_FIELD"),
+		timestampPrecision: r.oneOf("TIMESTAMP_PRECISION", "ns", "s", "ms", "us", "ns"),
+		keepJSONTimestamp:  r.get("KEEP_JSON_TIMESTAMP") != "",
+
+		logstashTags:   r.get("LOGSTASH_TAGS"),
+		logstashFields: r.get("LOGSTASH_FIELDS"),
+		decodeJsonLogs: r.get("DECODE_JSON_LOGS"),
+		decodeLogfmt:   r.get("DECODE_LOGFMT_LOGS"),
+		multiline:      make(map[string]string),
+
+		logstashFieldsTyped: r.get("LOGSTASH_FIELDS_TYPED") != "",
+	}
+
+	if c.timestampField == "" {
+		c.timestampField = "@timestamp"
+	}
+	if c.hostHostnameFile == "" {
+		c.hostHostnameFile = defaultHostHostnameFile
+	}
+
+	if c.compression != compressionZstd && c.compressionLevel > 9 {
+		r.fail("COMPRESSION_LEVEL", strconv.Itoa(c.compressionLevel))
+	}
+
+	if (c.tlsCertFile == "") != (c.tlsKeyFile == "") {
+		r.fail("TLS_CERT_FILE", c.tlsCertFile+" TLS_KEY_FILE="+c.tlsKeyFile)
+	}
+
+	if _, errs := ParseLogstashFields(c.logstashFields, c.logstashFieldsTyped); len(errs) > 0 {
+		r.fail("LOGSTASH_FIELDS", errs[0].Error())
+	}
+
+	for _, name := range []string{
+		"MULTILINE_START_PATTERN", "MULTILINE_CONTINUE_PATTERN",
+		"MULTILINE_MAX_LINES", "MULTILINE_MAX_BYTES", "MULTILINE_FLUSH_TIMEOUT",
+	} {
+		c.multiline[name] = r.get(name)
+	}
+	if _, err := newMultilineConfig(func(name string) string { return c.multiline[name] }); err != nil {
+		r.fail("MULTILINE", err.Error())
+	}
+
+	if len(r.invalid) > 0 {
+		return nil, errors.New("logstash: invalid settings: " + strings.Join(r.invalid, ", "))
+	}
+
+	return c, nil
+}
@@ -0,0 +1,111 @@
+package logstash
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteConfigDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	config, err := newRouteConfig(new(router.Route))
+	assert.Nil(err)
+
+	assert.Equal(defaultQueueSize, config.queueSize)
+	assert.Equal(overflowBlock, config.queueOverflow)
+	assert.Equal(defaultBackoffMin, config.backoffMin)
+	assert.Equal("@timestamp", config.timestampField)
+	assert.Equal("ns", config.timestampPrecision)
+	assert.False(config.retryStartup)
+	assert.Nil(config.includeContainers)
+	assert.Nil(config.includeContainersRegex)
+}
+
+func TestRouteConfigOptionsOverrideEnvironment(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("QUEUE_SIZE", "10")
+	os.Setenv("QUEUE_OVERFLOW", "drop-oldest")
+	defer os.Unsetenv("QUEUE_SIZE")
+	defer os.Unsetenv("QUEUE_OVERFLOW")
+
+	route := &router.Route{Options: map[string]string{
+		"queue_size":               "20",
+		"include_containers_regex": "^web-",
+		"reconnect_backoff_min":    "1s",
+	}}
+
+	config, err := newRouteConfig(route)
+	assert.Nil(err)
+
+	assert.Equal(20, config.queueSize)
+	assert.Equal(overflowDropOldest, config.queueOverflow)
+	assert.Equal(time.Second, config.backoffMin)
+	assert.True(config.includeContainersRegex.MatchString("web-1"))
+}
+
+func TestRouteConfigInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	route := &router.Route{Options: map[string]string{
+		"queue_size":               "0",
+		"queue_overflow":           "drop-everything",
+		"include_containers_regex": "(",
+		"logstash_fields":          "myfield",
+		"beats_timeout":            "soon",
+		"multiline_start_pattern":  "[",
+		"exclude_labels":           "=x,a=~(",
+		"exclude_images_regex":     "*",
+		"tls_min_version":          "1.4",
+		"tls_pins":                 "sha256/AAAA",
+		"tls_cert_file":            "client.pem",
+	}}
+
+	_, err := newRouteConfig(route)
+	assert.NotNil(err)
+	for _, name := range []string{"QUEUE_SIZE", "QUEUE_OVERFLOW", "INCLUDE_CONTAINERS_REGEX", "LOGSTASH_FIELDS", "BEATS_TIMEOUT", "MULTILINE", "EXCLUDE_LABELS", "EXCLUDE_IMAGES_REGEX", "TLS_MIN_VERSION", "TLS_PINS", "TLS_CERT_FILE"} {
+		assert.Contains(err.Error(), name)
+	}
+}
+
+func TestStreamRoutesWithDifferentOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+	containerConfig.Labels = map[string]string{"a.label": "yes"}
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	for _, labels := range []bool{true, false} {
+		options := map[string]string{"docker_labels": ""}
+		if labels {
+			options["docker_labels"] = "true"
+		}
+
+		adapter := newTestAdapter(MockConn{})
+		adapter.config, _ = newRouteConfig(&router.Route{Options: options})
+
+		data := map[string]interface{}{}
+		js, err := adapter.encode(&router.Message{Container: &container, Source: "stdout", Data: "foo", Time: time.Now()})
+		assert.Nil(err)
+		assert.Nil(json.Unmarshal(js, &data))
+
+		dockerInfo := data["docker"].(map[string]interface{})
+		if labels {
+			assert.Equal(map[string]interface{}{"a_label": "yes"}, dockerInfo["labels"])
+		} else {
+			assert.Nil(dockerInfo["labels"])
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package logstash
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultDNSTTL = 30 * time.Second
+	dnsTimeout    = 5 * time.Second
+)
+
+// resolver looks up the addresses of the Logstash endpoints. It is satisfied
+// by *net.Resolver.
+type resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// isSRVName returns true if address is an SRV record name, such as
+// _logstash._tcp.example.com, rather than a host and port.
+func isSRVName(address string) bool {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return false
+	}
+	labels := strings.SplitN(address, ".", 3)
+	return len(labels) == 3 && strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_")
+}
+
+// lookupSRV returns the host and port of every target of the SRV record
+// name, in order of priority and then weight. Unlike the order of the
+// lookup, which is randomized by weight, this order is the same for every
+// lookup, so a failover endpoint doesn't change after every refresh.
+func lookupSRV(r resolver, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	_, records, err := r.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		return records[i].Weight > records[j].Weight
+	})
+
+	addresses := make([]string, len(records))
+	for i, srv := range records {
+		addresses[i] = net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+	}
+	return addresses, nil
+}
+
+// resolveAddresses replaces the SRV record names of addresses with their
+// targets. It fails if a record can't be looked up or has no targets.
+func resolveAddresses(r resolver, addresses []string) ([]string, error) {
+	var resolved []string
+	for _, address := range addresses {
+		if !isSRVName(address) {
+			resolved = append(resolved, address)
+			continue
+		}
+
+		targets, err := lookupSRV(r, address)
+		if err == nil && len(targets) == 0 {
+			err = &net.DNSError{Err: "no SRV targets", Name: address}
+		}
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, targets...)
+	}
+	return resolved, nil
+}
+
+// resolveEndpoints sets the endpoints to the addresses of the route, with
+// their SRV record names looked up, and schedules the next lookup.
+func (a *LogstashAdapter) resolveEndpoints() error {
+	// Without endpoints they are looked up again even if refreshing is
+	// disabled.
+	ttl := a.config.dnsTTL
+	if ttl <= 0 {
+		ttl = defaultDNSTTL
+	}
+	a.refreshAt = time.Now().Add(ttl)
+
+	addresses, err := resolveAddresses(a.resolver, a.addresses)
+	if err != nil {
+		return err
+	}
+	a.updateEndpoints(addresses)
+	return nil
+}
+
+// refreshEndpoints looks up the addresses of the endpoints again once the DNS
+// TTL has passed. Endpoints discovered through SRV records are added and
+// removed as the records change, and a connection to an address that no
+// longer resolves to the connected IP is replaced.
+func (a *LogstashAdapter) refreshEndpoints(now time.Time) {
+	if a.resolver == nil || now.Before(a.refreshAt) || a.config.dnsTTL <= 0 && len(a.endpoints) > 0 {
+		return
+	}
+
+	if err := a.resolveEndpoints(); err != nil {
+		log.Println("logstash: could not refresh endpoints:", err)
+	}
+
+	for _, e := range a.endpoints {
+		if e.conn != nil && a.moved(e) {
+			a.migrate(e)
+		}
+	}
+}
+
+// refreshing returns true if the endpoints are looked up again after the DNS
+// TTL.
+func (a *LogstashAdapter) refreshing() bool {
+	return a.resolver != nil && a.config.dnsTTL > 0
+}
+
+// updateEndpoints sets the endpoints to addresses, keeping the state of the
+// endpoints that are still present and closing the connections of the ones
+// that are gone.
+func (a *LogstashAdapter) updateEndpoints(addresses []string) {
+	current := make(map[string]*endpoint, len(a.endpoints))
+	for _, e := range a.endpoints {
+		current[e.address] = e
+	}
+
+	endpoints := make([]*endpoint, 0, len(addresses))
+	for _, e := range newEndpoints(addresses, a.config) {
+		if existing, ok := current[e.address]; ok {
+			e = existing
+			delete(current, e.address)
+		} else if len(a.endpoints) > 0 {
+			log.Println("logstash: discovered endpoint", e.address)
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	for address, e := range current {
+		log.Println("logstash: removed endpoint", address)
+		if e.conn != nil {
+			e.conn.Close()
+		}
+	}
+	a.endpoints = endpoints
+}
+
+// moved returns true if the host of e no longer resolves to the IP it is
+// connected to. It returns false if that can't be told.
+func (a *LogstashAdapter) moved(e *endpoint) bool {
+	host, _, err := net.SplitHostPort(e.address)
+	if err != nil || net.ParseIP(host) != nil || e.conn.RemoteAddr() == nil {
+		return false
+	}
+	connected, _, err := net.SplitHostPort(e.conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	ips, err := a.resolver.LookupHost(ctx, host)
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+
+	for _, ip := range ips {
+		if net.ParseIP(ip).Equal(net.ParseIP(connected)) {
+			return false
+		}
+	}
+	return true
+}
+
+// migrate replaces the connection of e with a new one to the address it now
+// resolves to. Events are only written between batches, so the old
+// connection has nothing in flight and is closed once the new one is up. If
+// the new connection fails the old one is kept.
+func (a *LogstashAdapter) migrate(e *endpoint) {
+	conn, err := a.transport.Dial(e.address, a.route.Options)
+	if err != nil {
+		log.Println("logstash: could not reconnect to", e.address, "after a DNS change:", err)
+		return
+	}
+
+	log.Println("logstash: reconnected to", e.address, "after a DNS change")
+	e.conn.Close()
+	e.conn = conn
+	atomic.AddUint64(&a.metrics.reconnects, 1)
+}
@@ -0,0 +1,204 @@
+package logstash
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// FakeResolver answers lookups from maps, failing for unknown names.
+type FakeResolver struct {
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+}
+
+func (r *FakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ips, ok := r.hosts[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host}
+}
+
+func (r *FakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if records, ok := r.srv[name]; ok {
+		return name, records, nil
+	}
+	return "", nil, &net.DNSError{Err: "no such host", Name: name}
+}
+
+// RemoteConn is a connection to a remote IP.
+type RemoteConn struct {
+	ClosingConn
+	ip string
+}
+
+func (m RemoteConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(m.ip), Port: 5000}
+}
+
+func dnsAdapter(addresses ...string) (*LogstashAdapter, *AddressTransport, *FakeResolver) {
+	adapter, transport := endpointsAdapter(strategyFailover)
+	resolver := &FakeResolver{hosts: make(map[string][]string), srv: make(map[string][]*net.SRV)}
+	adapter.addresses = addresses
+	adapter.resolver = resolver
+	return adapter, transport, resolver
+}
+
+func TestIsSRVName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isSRVName("_logstash._tcp.example.com"))
+	assert.False(isSRVName("logstash.example.com:5000"))
+	assert.False(isSRVName("_logstash._tcp.example.com:5000"))
+	assert.False(isSRVName("_logstash.example"))
+}
+
+func TestResolveAddressesSRV(t *testing.T) {
+	assert := assert.New(t)
+
+	resolver := &FakeResolver{srv: map[string][]*net.SRV{
+		"_logstash._tcp.example": {
+			{Target: "c.example.", Port: 5002, Priority: 20, Weight: 10},
+			{Target: "b.example.", Port: 5001, Priority: 10, Weight: 10},
+			{Target: "a.example.", Port: 5000, Priority: 10, Weight: 50},
+		},
+		"_empty._tcp.example": {},
+	}}
+
+	addresses, err := resolveAddresses(resolver, []string{"_logstash._tcp.example", "d.example:5003"})
+	assert.Nil(err)
+	assert.Equal([]string{"a.example:5000", "b.example:5001", "c.example:5002", "d.example:5003"}, addresses)
+
+	_, err = resolveAddresses(resolver, []string{"_empty._tcp.example"})
+	assert.NotNil(err)
+	_, err = resolveAddresses(resolver, []string{"_missing._tcp.example"})
+	assert.NotNil(err)
+}
+
+func TestRefreshMigratesMovedConnection(t *testing.T) {
+	assert := assert.New(t)
+
+	var oldWrites, newWrites []string
+	var oldClosed, newClosed int32
+	adapter, transport, resolver := dnsAdapter("logstash:5000")
+	resolver.hosts["logstash"] = []string{"10.0.0.1"}
+	transport.conns["logstash:5000"] = RemoteConn{ClosingConn{RecordingConn{writes: &oldWrites}, &oldClosed}, "10.0.0.1"}
+
+	assert.Nil(adapter.resolveEndpoints())
+	assert.Nil(adapter.connect())
+	assert.True(adapter.tryWrite([][]byte{[]byte("1")}))
+
+	// The address moves to another IP, after the TTL has passed.
+	resolver.hosts["logstash"] = []string{"10.0.0.2"}
+	transport.conns["logstash:5000"] = RemoteConn{ClosingConn{RecordingConn{writes: &newWrites}, &newClosed}, "10.0.0.2"}
+	assert.True(adapter.tryWrite([][]byte{[]byte("2")}))
+	assert.Equal([]string{"1\n", "2\n"}, oldWrites)
+
+	adapter.refreshAt = time.Now()
+	assert.True(adapter.tryWrite([][]byte{[]byte("3")}))
+	assert.Equal([]string{"1\n", "2\n"}, oldWrites)
+	assert.Equal([]string{"3\n"}, newWrites)
+	assert.Equal(int32(1), oldClosed)
+	assert.Equal(int32(0), newClosed)
+	assert.Equal(uint64(1), adapter.metrics.reconnects)
+
+	// The IP is still one of the addresses, so the connection is kept.
+	resolver.hosts["logstash"] = []string{"10.0.0.3", "10.0.0.2"}
+	adapter.refreshAt = time.Now()
+	assert.True(adapter.tryWrite([][]byte{[]byte("4")}))
+	assert.Equal([]string{"3\n", "4\n"}, newWrites)
+	assert.Equal(int32(0), newClosed)
+}
+
+func TestRefreshKeepsConnectionWhenMigrationFails(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	var closed int32
+	adapter, transport, resolver := dnsAdapter("logstash:5000")
+	resolver.hosts["logstash"] = []string{"10.0.0.2"}
+	transport.conns["logstash:5000"] = RemoteConn{ClosingConn{RecordingConn{writes: &writes}, &closed}, "10.0.0.1"}
+
+	assert.Nil(adapter.resolveEndpoints())
+	assert.Nil(adapter.connect())
+	delete(transport.conns, "logstash:5000")
+
+	adapter.refreshAt = time.Now()
+	assert.True(adapter.tryWrite([][]byte{[]byte("1")}))
+	assert.Equal([]string{"1\n"}, writes)
+	assert.Equal(int32(0), closed)
+}
+
+func TestRefreshUpdatesSRVEndpoints(t *testing.T) {
+	assert := assert.New(t)
+
+	var aWrites, cWrites []string
+	var aClosed, bClosed int32
+	adapter, transport, resolver := dnsAdapter("_logstash._tcp.example")
+	resolver.srv["_logstash._tcp.example"] = []*net.SRV{
+		{Target: "a.example.", Port: 5000, Priority: 10},
+		{Target: "b.example.", Port: 5000, Priority: 20},
+	}
+	transport.conns["a.example:5000"] = ClosingConn{RecordingConn{writes: &aWrites}, &aClosed}
+	transport.conns["b.example:5000"] = ClosingConn{RecordingConn{}, &bClosed}
+	transport.conns["c.example:5000"] = RecordingConn{writes: &cWrites}
+
+	assert.Nil(adapter.resolveEndpoints())
+	assert.Nil(adapter.connect())
+	assert.Equal(2, len(adapter.endpoints))
+	first := adapter.endpoints[0]
+	adapter.endpoints[1].conn = transport.conns["b.example:5000"]
+
+	// b is replaced by c with a higher priority than a.
+	resolver.srv["_logstash._tcp.example"] = []*net.SRV{
+		{Target: "c.example.", Port: 5000, Priority: 5},
+		{Target: "a.example.", Port: 5000, Priority: 10},
+	}
+	adapter.refreshAt = time.Now()
+	assert.True(adapter.tryWrite([][]byte{[]byte("1")}))
+
+	assert.Equal("c.example:5000", adapter.endpoints[0].address)
+	assert.Equal(first, adapter.endpoints[1])
+	assert.Equal(2, len(adapter.endpoints))
+	assert.Equal(int32(1), bClosed)
+	assert.Equal(int32(0), aClosed)
+	assert.Equal([]string{"1\n"}, cWrites)
+
+	// A failed lookup keeps the endpoints.
+	delete(resolver.srv, "_logstash._tcp.example")
+	adapter.refreshAt = time.Now()
+	assert.True(adapter.tryWrite([][]byte{[]byte("2")}))
+	assert.Equal(2, len(adapter.endpoints))
+	assert.Equal([]string{"1\n", "2\n"}, cWrites)
+	assert.Empty(aWrites)
+}
+
+func TestRefreshDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter, transport, resolver := dnsAdapter("_logstash._tcp.example")
+	adapter.config.dnsTTL = 0
+	resolver.srv["_logstash._tcp.example"] = []*net.SRV{{Target: "a.example.", Port: 5000}}
+	transport.conns["a.example:5000"] = RecordingConn{writes: new([]string)}
+
+	assert.Nil(adapter.resolveEndpoints())
+	resolver.srv["_logstash._tcp.example"] = []*net.SRV{{Target: "b.example.", Port: 5000}}
+	adapter.refreshAt = time.Now()
+	assert.True(adapter.tryWrite([][]byte{[]byte("1")}))
+	assert.Equal("a.example:5000", adapter.endpoints[0].address)
+}
+
+func TestNoEndpointsWaitsForRefresh(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter, _, _ := dnsAdapter("_logstash._tcp.example")
+	assert.NotNil(adapter.resolveEndpoints())
+	assert.Empty(adapter.endpoints)
+	assert.NotNil(adapter.connect())
+	assert.False(adapter.tryWrite([][]byte{[]byte("1")}))
+	assert.Equal(adapter.refreshAt, adapter.redialAt())
+	assert.True(adapter.redialAt().After(time.Now()))
+}
@@ -0,0 +1,36 @@
+package logstash
+
+import (
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	schemaLogstash = "logstash"
+	schemaECS      = "ecs"
+
+	ecsVersion = "8.11.0"
+)
+
+// ecsFields returns the container, host and stream of m using the field
+// names of the Elastic Common Schema.
+func ecsFields(dockerInfo DockerInfo, host string, m *router.Message) map[string]interface{} {
+	container := map[string]interface{}{
+		"id":   dockerInfo.ID,
+		"name": dockerInfo.Name,
+		"image": map[string]interface{}{
+			"name": dockerInfo.Image,
+		},
+	}
+	if dockerInfo.Labels != nil {
+		container["labels"] = dockerInfo.Labels
+	}
+
+	return map[string]interface{}{
+		"ecs":       map[string]interface{}{"version": ecsVersion},
+		"container": container,
+		"host":      map[string]interface{}{"hostname": host},
+		"log": map[string]interface{}{
+			"origin": map[string]interface{}{"stream": m.Source},
+		},
+	}
+}
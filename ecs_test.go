@@ -0,0 +1,54 @@
+package logstash
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeECS(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	adapter.config, _ = newRouteConfig(&router.Route{Options: map[string]string{
+		"output_schema": "ecs",
+		"docker_labels": "true",
+	}})
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+	containerConfig.Env = []string{"LOGSTASH_TAGS=example,tags", "LOGSTASH_FIELDS=myfield=something"}
+	containerConfig.Labels = map[string]string{"com.example.team": "web"}
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	js, err := adapter.encode(&router.Message{Container: &container, Source: "stderr", Data: "foo bananas", Time: time.Now()})
+	assert.Nil(err)
+
+	var data map[string]interface{}
+	assert.Nil(json.Unmarshal(js, &data))
+
+	assert.Equal("foo bananas", data["message"])
+	assert.Equal("something", data["myfield"])
+	assert.Equal([]interface{}{"example", "tags"}, data["tags"])
+	assert.Equal(map[string]interface{}{"version": ecsVersion}, data["ecs"])
+	assert.Equal(map[string]interface{}{
+		"id":     "ID",
+		"name":   "name",
+		"image":  map[string]interface{}{"name": "image"},
+		"labels": map[string]interface{}{"com_example_team": "web"},
+	}, data["container"])
+	assert.Equal(map[string]interface{}{"hostname": "hostname"}, data["host"])
+	assert.Equal(map[string]interface{}{"origin": map[string]interface{}{"stream": "stderr"}}, data["log"])
+	assert.NotContains(data, "docker")
+	assert.NotContains(data, "stream")
+	assert.Contains(data, "@timestamp")
+}
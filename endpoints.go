@@ -0,0 +1,195 @@
+package logstash
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	strategyFailover    = "failover"
+	strategyRoundRobin  = "round-robin"
+	strategyLeastLoaded = "least-loaded"
+
+	defaultEndpointCooldown = 30 * time.Second
+)
+
+// endpoint is one of the Logstash addresses of a route, with its own
+// connection and reconnection state. After a failure the endpoint is
+// unhealthy until redialAt, when it may be dialled again.
+type endpoint struct {
+	address  string
+	conn     net.Conn
+	backoff  backoff
+	redialAt time.Time
+
+	// connected is set once the endpoint has had a connection, after which
+	// dialling it again counts as a reconnect.
+	connected bool
+
+	// latency is a moving average of the time taken to write an event.
+	latency time.Duration
+}
+
+// endpointAddresses returns the addresses of the endpoints of a route: the
+// ENDPOINTS setting, or else the comma separated route address.
+func endpointAddresses(address string, config *routeConfig) []string {
+	list := config.endpoints
+	if len(list) == 0 {
+		list = strings.Split(address, ",")
+	}
+
+	var addresses []string
+	for _, a := range list {
+		if a = strings.TrimSpace(a); a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	if len(addresses) == 0 {
+		return []string{address}
+	}
+	return addresses
+}
+
+func newEndpoints(addresses []string, config *routeConfig) []*endpoint {
+	endpoints := make([]*endpoint, len(addresses))
+	for i, address := range addresses {
+		endpoints[i] = &endpoint{
+			address: address,
+			backoff: backoff{min: config.backoffMin, max: config.backoffMax},
+		}
+	}
+	return endpoints
+}
+
+func (e *endpoint) healthy(now time.Time) bool {
+	return e.conn != nil || !now.Before(e.redialAt)
+}
+
+// fail closes the connection of e and keeps it from being used until the
+// backoff delay, or the cooldown if there are other endpoints to use, has
+// passed.
+func (e *endpoint) fail(cooldown time.Duration) {
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+
+	delay := e.backoff.next()
+	if delay < cooldown {
+		delay = cooldown
+	}
+	e.redialAt = time.Now().Add(delay)
+}
+
+// succeed records that e wrote events in d.
+func (e *endpoint) succeed(events int, d time.Duration) {
+	e.backoff.reset()
+
+	perEvent := d / time.Duration(events)
+	if e.latency == 0 {
+		e.latency = perEvent
+	} else {
+		e.latency = (4*e.latency + perEvent) / 5
+	}
+}
+
+// cooldown returns how long a failed endpoint is skipped. A single endpoint
+// only waits for its backoff delay.
+func (a *LogstashAdapter) cooldown() time.Duration {
+	if len(a.endpoints) < 2 {
+		return 0
+	}
+	return a.config.endpointCooldown
+}
+
+// ordered returns the endpoints in the order they should be tried: in the
+// configured order for failover, so the first healthy one is active, starting
+// after the last one used for round-robin, and fastest first for
+// least-loaded.
+func (a *LogstashAdapter) ordered() []*endpoint {
+	endpoints := make([]*endpoint, 0, len(a.endpoints))
+
+	switch a.config.endpointStrategy {
+	case strategyRoundRobin:
+		for i := range a.endpoints {
+			endpoints = append(endpoints, a.endpoints[(a.nextEndpoint+i)%len(a.endpoints)])
+		}
+		a.nextEndpoint = (a.nextEndpoint + 1) % len(a.endpoints)
+	case strategyLeastLoaded:
+		endpoints = append(endpoints, a.endpoints...)
+		sort.SliceStable(endpoints, func(i, j int) bool { return endpoints[i].latency < endpoints[j].latency })
+	default:
+		endpoints = append(endpoints, a.endpoints...)
+	}
+	return endpoints
+}
+
+// candidates returns the healthy endpoints, in the order they should be
+// tried.
+func (a *LogstashAdapter) candidates(now time.Time) []*endpoint {
+	var healthy []*endpoint
+	for _, e := range a.ordered() {
+		if e.healthy(now) {
+			healthy = append(healthy, e)
+		}
+	}
+	return healthy
+}
+
+// redialAt returns when the next endpoint becomes healthy again, or when the
+// endpoints are looked up again if that is sooner.
+func (a *LogstashAdapter) redialAt() time.Time {
+	var t time.Time
+	for i, e := range a.endpoints {
+		if i == 0 || e.redialAt.Before(t) {
+			t = e.redialAt
+		}
+	}
+	if len(a.endpoints) == 0 || (a.refreshing() && a.refreshAt.Before(t)) {
+		return a.refreshAt
+	}
+	return t
+}
+
+// dial connects e, counting a reconnect if it was connected before.
+func (a *LogstashAdapter) dial(e *endpoint) error {
+	conn, err := a.transport.Dial(e.address, a.route.Options)
+	if err != nil {
+		log.Println("logstash: could not connect to", e.address+":", err)
+		e.fail(a.cooldown())
+		return err
+	}
+	if e.connected {
+		atomic.AddUint64(&a.metrics.reconnects, 1)
+	}
+	e.conn = conn
+	e.connected = true
+	return nil
+}
+
+// connect connects the first endpoint that can be dialled, in the order of
+// the strategy, when the adapter is created.
+func (a *LogstashAdapter) connect() error {
+	err := errors.New("logstash: no endpoints")
+	for _, e := range a.ordered() {
+		if err = a.dial(e); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// closeConns closes the connections of every endpoint.
+func (a *LogstashAdapter) closeConns() {
+	for _, e := range a.endpoints {
+		if e.conn != nil {
+			e.conn.Close()
+			e.conn = nil
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package logstash
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+// AddressTransport dials the connection registered for an address, and
+// fails for any other address.
+type AddressTransport struct {
+	conns map[string]net.Conn
+	dials map[string]int
+}
+
+func (t *AddressTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	t.dials[addr]++
+	if conn, ok := t.conns[addr]; ok {
+		return conn, nil
+	}
+	return nil, errors.New("connection refused")
+}
+
+func endpointsAdapter(strategy string, addresses ...string) (*LogstashAdapter, *AddressTransport) {
+	adapter := newTestAdapter(nil)
+	adapter.config.endpointStrategy = strategy
+	adapter.endpoints = newEndpoints(addresses, adapter.config)
+
+	transport := &AddressTransport{conns: make(map[string]net.Conn), dials: make(map[string]int)}
+	adapter.transport = transport
+	return adapter, transport
+}
+
+func TestEndpointAddresses(t *testing.T) {
+	assert := assert.New(t)
+
+	config, _ := newRouteConfig(&router.Route{})
+	assert.Equal([]string{"logstash:5000"}, endpointAddresses("logstash:5000", config))
+	assert.Equal([]string{"a:5000", "b:5000"}, endpointAddresses("a:5000, b:5000,", config))
+
+	config, _ = newRouteConfig(&router.Route{Options: map[string]string{"endpoints": "c:5000,d:5000"}})
+	assert.Equal([]string{"c:5000", "d:5000"}, endpointAddresses("a:5000", config))
+}
+
+func TestEndpointsFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	var primary, secondary []string
+	adapter, transport := endpointsAdapter(strategyFailover, "primary", "secondary")
+	transport.conns["primary"] = ErrConn{}
+	transport.conns["secondary"] = RecordingConn{writes: &secondary}
+
+	// The primary fails, so the events go to the secondary.
+	assert.True(adapter.tryWrite([][]byte{[]byte("1")}))
+	assert.Equal([]string{"1\n"}, secondary)
+	assert.Nil(adapter.endpoints[0].conn)
+	assert.True(adapter.endpoints[0].redialAt.After(time.Now().Add(20 * time.Second)))
+
+	// The primary is skipped while cooling down.
+	assert.True(adapter.tryWrite([][]byte{[]byte("2")}))
+	assert.Equal([]string{"1\n", "2\n"}, secondary)
+	assert.Equal(1, transport.dials["primary"])
+
+	// Once cooled down the primary is active again.
+	transport.conns["primary"] = RecordingConn{writes: &primary}
+	adapter.endpoints[0].redialAt = time.Now()
+	assert.True(adapter.tryWrite([][]byte{[]byte("3")}))
+	assert.Equal([]string{"3\n"}, primary)
+	assert.Equal([]string{"1\n", "2\n"}, secondary)
+}
+
+func TestEndpointsRoundRobin(t *testing.T) {
+	assert := assert.New(t)
+
+	var a, b []string
+	adapter, transport := endpointsAdapter(strategyRoundRobin, "a", "b")
+	transport.conns["a"] = RecordingConn{writes: &a}
+	transport.conns["b"] = RecordingConn{writes: &b}
+
+	for _, js := range []string{"1", "2", "3", "4"} {
+		assert.True(adapter.tryWrite([][]byte{[]byte(js)}))
+	}
+	assert.Equal([]string{"1\n", "3\n"}, a)
+	assert.Equal([]string{"2\n", "4\n"}, b)
+	assert.Equal(1, transport.dials["a"])
+	assert.Equal(1, transport.dials["b"])
+
+	// The first connection of an endpoint isn't a reconnect.
+	assert.Equal(uint64(0), adapter.metrics.reconnects)
+
+	adapter.endpoints[0].fail(0)
+	adapter.endpoints[0].redialAt = time.Now()
+	assert.True(adapter.tryWrite([][]byte{[]byte("5")}))
+	assert.True(adapter.tryWrite([][]byte{[]byte("6")}))
+	assert.Equal([]string{"1\n", "3\n", "5\n"}, a)
+	assert.Equal(uint64(1), adapter.metrics.reconnects)
+}
+
+func TestEndpointsLeastLoaded(t *testing.T) {
+	assert := assert.New(t)
+
+	var slow, fast []string
+	adapter, transport := endpointsAdapter(strategyLeastLoaded, "slow", "fast")
+	transport.conns["slow"] = RecordingConn{writes: &slow}
+	transport.conns["fast"] = RecordingConn{writes: &fast}
+	adapter.endpoints[0].latency = 10 * time.Millisecond
+	adapter.endpoints[1].latency = time.Millisecond
+
+	assert.True(adapter.tryWrite([][]byte{[]byte("1")}))
+	assert.Empty(slow)
+	assert.Equal([]string{"1\n"}, fast)
+}
+
+func TestEndpointsAllUnhealthy(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter, transport := endpointsAdapter(strategyFailover, "a", "b")
+
+	assert.False(adapter.tryWrite([][]byte{[]byte("1")}))
+	assert.Equal(1, transport.dials["a"])
+	assert.Equal(1, transport.dials["b"])
+
+	// Nothing is dialled until an endpoint has cooled down.
+	assert.False(adapter.tryWrite([][]byte{[]byte("1")}))
+	assert.Equal(1, transport.dials["a"])
+
+	adapter.endpoints[1].redialAt = time.Now().Add(time.Second)
+	assert.Equal(adapter.endpoints[1].redialAt, adapter.redialAt())
+}
+
+func TestEndpointSingleUsesBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter, _ := endpointsAdapter(strategyFailover, "a")
+	adapter.endpoints[0].backoff = backoff{min: time.Millisecond, max: time.Millisecond}
+
+	assert.False(adapter.tryWrite([][]byte{[]byte("1")}))
+	assert.True(adapter.endpoints[0].redialAt.Before(time.Now().Add(time.Second)))
+}
@@ -0,0 +1,262 @@
+package logstash
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// logstashField is a single key=value entry of LOGSTASH_FIELDS.
+type logstashField struct {
+	key   string
+	value interface{}
+}
+
+// fieldsParser parses LOGSTASH_FIELDS: a comma separated list of key=value
+// entries. Keys and values are trimmed of surrounding whitespace and may be
+// quoted with double or single quotes. A backslash escapes the character
+// after it, so \, and \= can be used outside of quotes, and \n and \t stand
+// for a newline and a tab.
+type fieldsParser struct {
+	input string
+	pos   int
+}
+
+// ParseLogstashFields parses s, in order. Malformed entries are skipped and
+// returned as errors. In typed mode, unquoted numbers, booleans and null
+// become JSON numbers, booleans and null instead of strings.
+func ParseLogstashFields(s string, typed bool) ([]logstashField, []error) {
+	p := &fieldsParser{input: s}
+
+	var fields []logstashField
+	var errs []error
+
+	for p.pos < len(p.input) {
+		start := p.pos
+		f, err := p.entry(typed)
+		if err != nil {
+			p.skipEntry()
+			entry := strings.TrimSuffix(p.input[start:p.pos], ",")
+			errs = append(errs, fmt.Errorf("invalid entry %q: %v", entry, err))
+			continue
+		}
+		if f != nil {
+			fields = append(fields, *f)
+		}
+	}
+
+	return fields, errs
+}
+
+// entry parses one key=value entry and the comma after it. It returns nil if
+// the entry is empty.
+func (p *fieldsParser) entry(typed bool) (*logstashField, error) {
+	key, keyQuoted, err := p.token(",=")
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.input) || p.input[p.pos] == ',' {
+		if key == "" && !keyQuoted {
+			p.next()
+			return nil, nil
+		}
+		return nil, fmt.Errorf("missing '='")
+	}
+	if key == "" {
+		return nil, fmt.Errorf("empty key")
+	}
+
+	// Skip the '='. The value ends at the next comma, it may contain '='.
+	p.next()
+	value, valueQuoted, err := p.token(",")
+	if err != nil {
+		return nil, err
+	}
+	p.next()
+
+	f := &logstashField{key: key, value: value}
+	if typed && !valueQuoted {
+		f.value = typedValue(value)
+	}
+	return f, nil
+}
+
+// token reads a possibly quoted token, up to the first unescaped character
+// in stop or the end of the input.
+func (p *fieldsParser) token(stop string) (string, bool, error) {
+	p.skipSpace()
+
+	if p.pos < len(p.input) && (p.input[p.pos] == '"' || p.input[p.pos] == '\'') {
+		quote := p.input[p.pos]
+		p.pos++
+
+		var b strings.Builder
+		for {
+			if p.pos >= len(p.input) {
+				return "", true, fmt.Errorf("unterminated quote")
+			}
+			c := p.input[p.pos]
+			p.pos++
+			if c == quote {
+				break
+			}
+			if c == '\\' && p.pos < len(p.input) {
+				c = unescape(p.input[p.pos])
+				p.pos++
+			}
+			b.WriteByte(c)
+		}
+
+		p.skipSpace()
+		if p.pos < len(p.input) && !strings.ContainsRune(stop, rune(p.input[p.pos])) {
+			return "", true, fmt.Errorf("unexpected %q after quote", p.input[p.pos])
+		}
+		return b.String(), true, nil
+	}
+
+	var b strings.Builder
+	trimmed := 0
+	for p.pos < len(p.input) && !strings.ContainsRune(stop, rune(p.input[p.pos])) {
+		c := p.input[p.pos]
+		p.pos++
+		if c == '\\' && p.pos < len(p.input) {
+			b.WriteByte(unescape(p.input[p.pos]))
+			p.pos++
+			trimmed = b.Len()
+			continue
+		}
+		b.WriteByte(c)
+	}
+
+	// Trailing whitespace is trimmed, unless it was escaped.
+	s := b.String()
+	return s[:trimmed] + strings.TrimRight(s[trimmed:], " \t"), false, nil
+}
+
+// next skips the current character, if there is one.
+func (p *fieldsParser) next() {
+	if p.pos < len(p.input) {
+		p.pos++
+	}
+}
+
+func (p *fieldsParser) skipSpace() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipEntry skips past the next comma that isn't quoted or escaped.
+func (p *fieldsParser) skipEntry() {
+	var quote byte
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			p.next()
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			return
+		}
+	}
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	}
+	return c
+}
+
+// typedValue returns value as a JSON number, boolean or null if it looks
+// like one, and else as a string.
+func typedValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+
+	if jsonNumber.MatchString(value) {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+
+	return value
+}
+
+var bracketPath = regexp.MustCompile(`^(\[[^\[\]]+\])+$`)
+
+// fieldPath returns the names of the nested objects a field key refers to,
+// using either dots, service.name, or brackets, [service][name]. Brackets
+// allow names containing dots, such as [kubernetes.io].
+func fieldPath(key string) []string {
+	if bracketPath.MatchString(key) {
+		return strings.Split(key[1:len(key)-1], "][")
+	}
+
+	var path []string
+	for _, name := range strings.Split(key, ".") {
+		if name != "" {
+			path = append(path, name)
+		}
+	}
+	if len(path) == 0 {
+		return []string{key}
+	}
+	return path
+}
+
+// setField sets the field at path in m, creating the objects along the path.
+// A value that isn't an object along the path is replaced by one, so when
+// fields conflict the one set last wins.
+func setField(m map[string]interface{}, path []string, value interface{}) {
+	for _, name := range path[:len(path)-1] {
+		next, ok := m[name].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[name] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
+
+// mergeFields merges src into dst. Objects present in both are merged
+// recursively, any other value of src replaces the one in dst. Objects of src
+// are copied, so later merges into dst don't modify src.
+func mergeFields(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcObject, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+
+		dstObject, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dstObject = make(map[string]interface{})
+			dst[k] = dstObject
+		}
+		mergeFields(dstObject, srcObject)
+	}
+}
@@ -0,0 +1,195 @@
+package logstash
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func fieldsMap(fields []logstashField) map[string]interface{} {
+	m := map[string]interface{}{}
+	for _, f := range fields {
+		m[f.key] = f.value
+	}
+	return m
+}
+
+func TestParseLogstashFields(t *testing.T) {
+	assert := assert.New(t)
+
+	fields, errs := ParseLogstashFields(`myfield=something, anotherfield = something else ,`, false)
+	assert.Empty(errs)
+	assert.Equal([]logstashField{
+		{key: "myfield", value: "something"},
+		{key: "anotherfield", value: "something else"},
+	}, fields)
+
+	fields, errs = ParseLogstashFields(`quoted="a, b = c",single='it''s',escaped=a\,b\=c,equals=a=b,ws=\ x\ ,nl="1\n2\"3"`, false)
+	assert.Len(errs, 1)
+	assert.Equal(map[string]interface{}{
+		"quoted":  "a, b = c",
+		"escaped": "a,b=c",
+		"equals":  "a=b",
+		"ws":      " x ",
+		"nl":      "1\n2\"3",
+	}, fieldsMap(fields))
+}
+
+func TestParseLogstashFieldsMalformed(t *testing.T) {
+	assert := assert.New(t)
+
+	fields, errs := ParseLogstashFields(`novalue,a=1,=empty,b="unterminated,c=3`, false)
+	assert.Len(errs, 3)
+	assert.Contains(errs[0].Error(), `"novalue"`)
+	assert.Contains(errs[1].Error(), "empty key")
+	assert.Contains(errs[2].Error(), "unterminated quote")
+	assert.Equal(map[string]interface{}{"a": "1"}, fieldsMap(fields))
+
+	fields, errs = ParseLogstashFields(`a="x"y,b=2`, false)
+	assert.Len(errs, 1)
+	assert.Equal(map[string]interface{}{"b": "2"}, fieldsMap(fields))
+}
+
+func TestParseLogstashFieldsTyped(t *testing.T) {
+	assert := assert.New(t)
+
+	fields, errs := ParseLogstashFields(`i=42,f=-1.5e3,t=true,f2=false,n=null,s="42",v=1.2.3,z=007`, true)
+	assert.Empty(errs)
+	assert.Equal(map[string]interface{}{
+		"i":  int64(42),
+		"f":  -1500.0,
+		"t":  true,
+		"f2": false,
+		"n":  nil,
+		"s":  "42",
+		"v":  "1.2.3",
+		"z":  "007",
+	}, fieldsMap(fields))
+}
+
+func TestGetLogstashFieldsMalformedDoesNotPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	adapter.config, _ = newRouteConfig(&router.Route{Options: map[string]string{"logstash_fields": ""}})
+
+	container := docker.Container{ID: "malformed", Config: &docker.Config{
+		Env: []string{"LOGSTASH_FIELDS=broken,myfield=something"},
+	}}
+
+	assert.Equal(map[string]interface{}{"myfield": "something"}, GetLogstashFields(&container, adapter))
+}
+
+func TestFieldPath(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"myfield"}, fieldPath("myfield"))
+	assert.Equal([]string{"service", "name"}, fieldPath("service.name"))
+	assert.Equal([]string{"kubernetes", "namespace"}, fieldPath("[kubernetes][namespace]"))
+	assert.Equal([]string{"kubernetes.io", "name"}, fieldPath("[kubernetes.io][name]"))
+	assert.Equal([]string{"a", "b"}, fieldPath(".a..b."))
+	assert.Equal([]string{"."}, fieldPath("."))
+	assert.Equal([]string{"[a]b"}, fieldPath("[a]b"))
+}
+
+func TestGetLogstashFieldsNested(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+
+	container := docker.Container{ID: "nested", Config: &docker.Config{
+		Env: []string{"LOGSTASH_FIELDS=service.name=api,[kubernetes][namespace]=prod,a=scalar,a.b=1,c.d=1,c=scalar"},
+	}}
+
+	assert.Equal(map[string]interface{}{
+		"service":    map[string]interface{}{"name": "api"},
+		"kubernetes": map[string]interface{}{"namespace": "prod"},
+		"a":          map[string]interface{}{"b": "1"},
+		"c":          "scalar",
+	}, GetLogstashFields(&container, adapter))
+}
+
+func TestMergeFields(t *testing.T) {
+	assert := assert.New(t)
+
+	src := map[string]interface{}{
+		"service": map[string]interface{}{"name": "api"},
+		"level":   "info",
+	}
+	dst := map[string]interface{}{
+		"service": map[string]interface{}{"name": "payload", "version": "1"},
+		"level":   map[string]interface{}{"value": "debug"},
+		"message": "hello",
+	}
+
+	mergeFields(dst, src)
+	assert.Equal(map[string]interface{}{
+		"service": map[string]interface{}{"name": "api", "version": "1"},
+		"level":   "info",
+		"message": "hello",
+	}, dst)
+
+	// Objects of src are copied, not shared.
+	other := map[string]interface{}{}
+	mergeFields(other, src)
+	other["service"].(map[string]interface{})["name"] = "changed"
+	assert.Equal("api", src["service"].(map[string]interface{})["name"])
+}
+
+func TestEncodeNestedFieldsMergeOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	adapter.config.decodeJsonLogs = "true"
+	adapter.config.logstashTags = ""
+
+	container := docker.Container{ID: "merge", Name: "name", Config: &docker.Config{
+		Env: []string{"LOGSTASH_FIELDS=service.name=api,docker.name=fields,stream=fields"},
+	}}
+	message := router.Message{
+		Container: &container,
+		Source:    "stdout",
+		Data:      `{"service": {"name": "payload", "version": "1"}, "docker": "payload", "tags": ["payload"]}`,
+	}
+
+	js, err := adapter.encode(&message)
+	assert.NoError(err)
+
+	var data map[string]interface{}
+	assert.NoError(json.Unmarshal(js, &data))
+	assert.Equal(map[string]interface{}{"name": "api", "version": "1"}, data["service"])
+	assert.Equal("name", data["docker"].(map[string]interface{})["name"])
+	assert.Equal("stdout", data["stream"])
+	assert.Equal([]interface{}{}, data["tags"])
+}
+
+func TestGetContainerLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	container := docker.Container{Config: &docker.Config{Labels: map[string]string{
+		"com.example.team":    "core",
+		"com.example.version": "2",
+		"log":                 "this",
+		"a":                   "scalar",
+		"a.b":                 "nested",
+	}}}
+
+	assert.Equal(map[string]interface{}{
+		"com_example_team":    "core",
+		"com_example_version": "2",
+		"log":                 "this",
+		"a":                   "scalar",
+		"a_b":                 "nested",
+	}, GetContainerLabels(&container, false))
+
+	assert.Equal(map[string]interface{}{
+		"com": map[string]interface{}{
+			"example": map[string]interface{}{"team": "core", "version": "2"},
+		},
+		"log": "this",
+		"a":   map[string]interface{}{"b": "nested"},
+	}, GetContainerLabels(&container, true))
+}
@@ -0,0 +1,138 @@
+package logstash
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// labelFilter matches containers by a label: one that has the label, one
+// where it equals a value, or one where it matches a regular expression.
+type labelFilter struct {
+	label string
+	value *string
+	regex *regexp.Regexp
+}
+
+// parseLabelFilter parses a label filter written as name, name=value or
+// name=~regex.
+func parseLabelFilter(s string) (labelFilter, error) {
+	s = strings.TrimSpace(s)
+
+	i := strings.Index(s, "=")
+	if i < 0 {
+		if s == "" {
+			return labelFilter{}, fmt.Errorf("empty label filter")
+		}
+		return labelFilter{label: s}, nil
+	}
+
+	f := labelFilter{label: strings.TrimSpace(s[:i])}
+	if f.label == "" {
+		return labelFilter{}, fmt.Errorf("missing label name in %q", s)
+	}
+
+	if strings.HasPrefix(s[i+1:], "~") {
+		re, err := regexp.Compile(s[i+2:])
+		if err != nil {
+			return labelFilter{}, err
+		}
+		f.regex = re
+		return f, nil
+	}
+
+	value := s[i+1:]
+	f.value = &value
+	return f, nil
+}
+
+func (f labelFilter) match(c *docker.Container) bool {
+	value, ok := c.Config.Labels[f.label]
+	switch {
+	case !ok:
+		return false
+	case f.regex != nil:
+		return f.regex.MatchString(value)
+	case f.value != nil:
+		return value == *f.value
+	}
+	return true
+}
+
+// matchAnyLabel returns true if any of filters matches c.
+func matchAnyLabel(c *docker.Container, filters []labelFilter) bool {
+	for _, f := range filters {
+		if f.match(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsContainerIncluded returns true if logs of c should be sent, according to
+// the container filters of the route. The decision is cached per container,
+// so the filters only run on the first message of a container.
+func IsContainerIncluded(c *docker.Container, a *LogstashAdapter) bool {
+	if included, ok := a.containers.get(c.ID, "included"); ok {
+		return included.(bool)
+	}
+
+	included := containerIncluded(c, a.config)
+
+	a.containers.set(c.ID, "included", included)
+
+	return included
+}
+
+// containerIncluded returns true if logs of c should be sent. Excludes take
+// precedence: a container matching EXCLUDE_LABELS, EXCLUDE_CONTAINERS,
+// EXCLUDE_CONTAINERS_REGEX, EXCLUDE_IMAGES or EXCLUDE_IMAGES_REGEX is skipped.
+// Otherwise, when INCLUDE_LABELS is set the container has to match it, and
+// when INCLUDE_CONTAINERS or INCLUDE_CONTAINERS_REGEX is set its name has to
+// match either of them.
+func containerIncluded(c *docker.Container, config *routeConfig) bool {
+	if matchAnyLabel(c, config.excludeLabels) ||
+		matchName(c.Name, config.excludeContainers, config.excludeContainersRegex) ||
+		matchImage(c.Config.Image, config.excludeImages, config.excludeImagesRegex) {
+		return false
+	}
+
+	if len(config.includeLabels) > 0 && !matchAnyLabel(c, config.includeLabels) {
+		return false
+	}
+	if len(config.includeContainers) > 0 || config.includeContainersRegex != nil {
+		return matchName(c.Name, config.includeContainers, config.includeContainersRegex)
+	}
+	return true
+}
+
+// matchName returns true if name is one of names or matches re.
+func matchName(name string, names []string, re *regexp.Regexp) bool {
+	for _, n := range names {
+		if name == n {
+			return true
+		}
+	}
+	return re != nil && re.MatchString(name)
+}
+
+// matchImage returns true if image, or its repository without the tag or
+// digest, is one of images, or if image matches re.
+func matchImage(image string, images []string, re *regexp.Regexp) bool {
+	repository := image
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository = repository[:i]
+	}
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+
+	for _, n := range images {
+		if image == n || repository == n {
+			return true
+		}
+	}
+	return re != nil && re.MatchString(image)
+}
@@ -0,0 +1,171 @@
+package logstash
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func filterConfig(t *testing.T, options map[string]string) *routeConfig {
+	config, err := newRouteConfig(&router.Route{Options: options})
+	assert.New(t).NoError(err)
+	return config
+}
+
+func labelledContainer(name string, labels map[string]string) *docker.Container {
+	return &docker.Container{Name: name, Config: &docker.Config{Labels: labels}}
+}
+
+func TestParseLabelFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := parseLabelFilter(" logstash.skip ")
+	assert.NoError(err)
+	assert.Equal("logstash.skip", f.label)
+	assert.Nil(f.value)
+	assert.Nil(f.regex)
+
+	f, err = parseLabelFilter("logstash.enable=true")
+	assert.NoError(err)
+	assert.Equal("logstash.enable", f.label)
+	assert.Equal("true", *f.value)
+
+	f, err = parseLabelFilter("logstash.enable=")
+	assert.NoError(err)
+	assert.Equal("", *f.value)
+
+	f, err = parseLabelFilter("team=~^(core|infra)$")
+	assert.NoError(err)
+	assert.Equal("team", f.label)
+	assert.NotNil(f.regex)
+
+	_, err = parseLabelFilter("")
+	assert.Error(err)
+	_, err = parseLabelFilter("=true")
+	assert.Error(err)
+	_, err = parseLabelFilter("team=~(")
+	assert.Error(err)
+}
+
+func TestContainerIncludedByLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	config := filterConfig(t, map[string]string{"include_labels": "logstash.enable=true,team=~^core"})
+
+	assert.True(containerIncluded(labelledContainer("a", map[string]string{"logstash.enable": "true"}), config))
+	assert.True(containerIncluded(labelledContainer("b", map[string]string{"team": "core-api"}), config))
+	assert.False(containerIncluded(labelledContainer("c", map[string]string{"logstash.enable": "false"}), config))
+	assert.False(containerIncluded(labelledContainer("d", map[string]string{"team": "infra"}), config))
+	assert.False(containerIncluded(labelledContainer("e", nil), config))
+}
+
+func TestContainerExcludedByLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	config := filterConfig(t, map[string]string{
+		"include_labels": "logstash.enable",
+		"exclude_labels": "logstash.skip",
+	})
+
+	assert.True(containerIncluded(labelledContainer("a", map[string]string{"logstash.enable": ""}), config))
+	assert.False(containerIncluded(labelledContainer("b", map[string]string{"logstash.enable": "", "logstash.skip": ""}), config))
+	assert.False(containerIncluded(labelledContainer("c", map[string]string{"logstash.skip": "true"}), config))
+}
+
+func TestContainerIncludedByLabelAndName(t *testing.T) {
+	assert := assert.New(t)
+
+	config := filterConfig(t, map[string]string{
+		"include_labels":     "logstash.enable=true",
+		"include_containers": "web,db",
+	})
+
+	enabled := map[string]string{"logstash.enable": "true"}
+	assert.True(containerIncluded(labelledContainer("web", enabled), config))
+	assert.False(containerIncluded(labelledContainer("cache", enabled), config))
+	assert.False(containerIncluded(labelledContainer("db", nil), config))
+}
+
+func imageContainer(name, image string) *docker.Container {
+	return &docker.Container{Name: name, Config: &docker.Config{Image: image}}
+}
+
+func TestContainerIncludedByNameListAndRegex(t *testing.T) {
+	assert := assert.New(t)
+
+	config := filterConfig(t, map[string]string{
+		"include_containers":       "web",
+		"include_containers_regex": "^worker-",
+	})
+
+	assert.True(containerIncluded(imageContainer("web", "nginx"), config))
+	assert.True(containerIncluded(imageContainer("worker-1", "app"), config))
+	assert.False(containerIncluded(imageContainer("db", "postgres"), config))
+}
+
+func TestContainerExcludedByName(t *testing.T) {
+	assert := assert.New(t)
+
+	config := filterConfig(t, map[string]string{
+		"include_containers_regex": "^worker-",
+		"exclude_containers":       "worker-debug",
+		"exclude_containers_regex": "-canary$",
+	})
+
+	assert.True(containerIncluded(imageContainer("worker-1", "app"), config))
+	assert.False(containerIncluded(imageContainer("worker-debug", "app"), config))
+	assert.False(containerIncluded(imageContainer("worker-2-canary", "app"), config))
+	assert.False(containerIncluded(imageContainer("web", "app"), config))
+}
+
+func TestContainerExcludedByImage(t *testing.T) {
+	assert := assert.New(t)
+
+	config := filterConfig(t, map[string]string{
+		"exclude_images":       "logspout,registry:5000/tools/debug",
+		"exclude_images_regex": "^busybox",
+	})
+
+	assert.False(containerIncluded(imageContainer("a", "logspout"), config))
+	assert.False(containerIncluded(imageContainer("b", "logspout:v3.2"), config))
+	assert.False(containerIncluded(imageContainer("c", "logspout@sha256:0123"), config))
+	assert.False(containerIncluded(imageContainer("d", "registry:5000/tools/debug:1"), config))
+	assert.False(containerIncluded(imageContainer("e", "busybox:latest"), config))
+	assert.True(containerIncluded(imageContainer("f", "registry:5000/tools/other"), config))
+	assert.True(containerIncluded(imageContainer("g", "logspout-logstash"), config))
+}
+
+func TestIsContainerIncludedCached(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	adapter.config = filterConfig(t, map[string]string{"exclude_containers": "skipped"})
+
+	container := imageContainer("skipped", "app")
+	container.ID = "cached"
+	assert.False(IsContainerIncluded(container, adapter))
+
+	// The decision is cached by container ID, not recomputed.
+	adapter.config = filterConfig(t, nil)
+	assert.False(IsContainerIncluded(container, adapter))
+
+	adapter = newTestAdapter(MockConn{})
+	assert.True(IsContainerIncluded(container, adapter))
+}
+
+func BenchmarkContainerIncluded(b *testing.B) {
+	config, _ := newRouteConfig(&router.Route{Options: map[string]string{
+		"include_containers_regex": "^(web|worker)-[0-9]+$",
+		"exclude_images_regex":     "^busybox",
+		"exclude_labels":           "logstash.skip,team=~^(qa|test)$",
+	}})
+	container := labelledContainer("worker-42", map[string]string{"team": "core"})
+	container.Config.Image = "registry:5000/app:1.2"
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		containerIncluded(container, config)
+	}
+}
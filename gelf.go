@@ -0,0 +1,205 @@
+package logstash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	encoderJSONLines = "json_lines"
+	encoderGELF      = "gelf"
+
+	gelfCompressionGzip = "gzip"
+	gelfCompressionZlib = "zlib"
+	gelfCompressionNone = "none"
+
+	defaultGELFChunkSize = 1420
+	gelfMaxChunks        = 128
+	gelfChunkHeaderSize  = 12
+
+	gelfLevelError = 3
+	gelfLevelInfo  = 6
+)
+
+var gelfFieldReplacer = regexp.MustCompile(`[^\w.\-]`)
+
+// encodeGELF returns the GELF 1.1 encoding of the event data for m. The first
+// line of the message becomes short_message and every other field of data
+// becomes an additional field, with nested objects flattened.
+func encodeGELF(data map[string]interface{}, m *router.Message, host, timestampField string) ([]byte, error) {
+	message, ok := data["message"].(string)
+	if !ok {
+		message = m.Data
+	}
+
+	level := gelfLevelInfo
+	if m.Source == "stderr" {
+		level = gelfLevelError
+	}
+
+	gelf := map[string]interface{}{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": strings.SplitN(message, "\n", 2)[0],
+		"timestamp":     float64(eventTime(m.Time).UnixNano()/int64(1e6)) / 1e3,
+		"level":         level,
+	}
+	if strings.Contains(message, "\n") {
+		gelf["full_message"] = message
+	}
+
+	for k, v := range data {
+		switch k {
+		case "message", "host", timestampField, "@version":
+			continue
+		}
+		addGELFFields(gelf, k, v)
+	}
+
+	return json.Marshal(gelf)
+}
+
+// addGELFFields adds v as the additional field named after key, or one
+// additional field for each value nested in v. Numbers are kept as numbers,
+// booleans become the strings true and false, and any other value a string.
+func addGELFFields(gelf map[string]interface{}, key string, v interface{}) {
+	switch value := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, v := range value {
+			addGELFFields(gelf, key+"_"+k, v)
+		}
+	case map[string]string:
+		for k, v := range value {
+			addGELFFields(gelf, key+"_"+k, v)
+		}
+	case DockerInfo:
+		addGELFFields(gelf, key, map[string]interface{}{
+			"name":     value.Name,
+			"id":       value.ID,
+			"image":    value.Image,
+			"hostname": value.Hostname,
+			"labels":   value.Labels,
+		})
+	case []string:
+		gelf[gelfFieldName(key)] = strings.Join(value, ",")
+	case []interface{}:
+		values := make([]string, len(value))
+		for i, v := range value {
+			values[i] = fmt.Sprint(v)
+		}
+		gelf[gelfFieldName(key)] = strings.Join(values, ",")
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, string:
+		gelf[gelfFieldName(key)] = value
+	case bool:
+		// GELF has no booleans.
+		gelf[gelfFieldName(key)] = strconv.FormatBool(value)
+	default:
+		gelf[gelfFieldName(key)] = fmt.Sprint(value)
+	}
+}
+
+// gelfFieldName returns the name of an additional field, which has to start
+// with an underscore and must not be _id.
+func gelfFieldName(key string) string {
+	name := "_" + gelfFieldReplacer.ReplaceAllString(key, "_")
+	if name == "_id" {
+		return "_id_"
+	}
+	return name
+}
+
+// gelfUDPProtocol sends every event as a compressed GELF datagram, split
+// into chunks if it is larger than the chunk size.
+type gelfUDPProtocol struct {
+	compression string
+	chunkSize   int
+}
+
+func (p *gelfUDPProtocol) writeBatch(conn net.Conn, events [][]byte) error {
+	for _, js := range events {
+		payload, err := p.compress(js)
+		if err != nil {
+			return err
+		}
+
+		chunks := p.chunks(payload)
+		if chunks == nil {
+			// Retrying will not make it any smaller.
+			log.Println("logstash: dropping GELF message, too many chunks:", len(payload), "bytes")
+			continue
+		}
+
+		for _, chunk := range chunks {
+			if _, err := conn.Write(chunk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *gelfUDPProtocol) compress(js []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch p.compression {
+	case gelfCompressionNone:
+		return js, nil
+	case gelfCompressionZlib:
+		w = zlib.NewWriter(&buf)
+	default:
+		w = gzip.NewWriter(&buf)
+	}
+
+	w.Write(js)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chunks splits payload into datagrams of at most the chunk size. It returns
+// nil if that takes more chunks than GELF allows.
+func (p *gelfUDPProtocol) chunks(payload []byte) [][]byte {
+	if len(payload) <= p.chunkSize {
+		return [][]byte{payload}
+	}
+
+	size := p.chunkSize - gelfChunkHeaderSize
+	count := (len(payload) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		chunk := make([]byte, 0, gelfChunkHeaderSize+end-i*size)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, payload[i*size:end]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
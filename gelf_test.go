@@ -0,0 +1,161 @@
+package logstash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeGELF(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	adapter.config, _ = newRouteConfig(&router.Route{Options: map[string]string{
+		"encoder":       "gelf",
+		"docker_labels": "true",
+	}})
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+	containerConfig.Env = []string{"LOGSTASH_TAGS=example,tags", "LOGSTASH_FIELDS=myfield=something"}
+	containerConfig.Labels = map[string]string{"com.example.team": "web"}
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	js, err := adapter.encode(&router.Message{
+		Container: &container,
+		Source:    "stderr",
+		Data:      `{ "message": "boom\n\tat Main.java", "id": 7, "ok": true }`,
+		Time:      time.Unix(1500000000, 123456789),
+	})
+	assert.Nil(err)
+
+	var data map[string]interface{}
+	assert.Nil(json.Unmarshal(js, &data))
+
+	assert.Equal("1.1", data["version"])
+	assert.Equal("hostname", data["host"])
+	assert.Equal("boom", data["short_message"])
+	assert.Equal("boom\n\tat Main.java", data["full_message"])
+	assert.Equal(1500000000.123, data["timestamp"])
+	assert.Equal(float64(gelfLevelError), data["level"])
+	assert.Equal("something", data["_myfield"])
+	assert.Equal("example,tags", data["_tags"])
+	assert.Equal("stderr", data["_stream"])
+	assert.Equal("name", data["_docker_name"])
+	assert.Equal("web", data["_docker_labels_com_example_team"])
+	assert.Equal(float64(7), data["_id_"])
+	assert.Equal("true", data["_ok"])
+	assert.NotContains(data, "_id")
+	assert.NotContains(data, "_@timestamp")
+	assert.NotContains(data, "message")
+}
+
+func TestEncodeGELFTypedFields(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	adapter.config, _ = newRouteConfig(&router.Route{Options: map[string]string{
+		"encoder":               "gelf",
+		"logstash_fields":       `port=8080,ratio=0.5,on=true,off=false,none=null,name="8080"`,
+		"logstash_fields_typed": "true",
+	}})
+	container := docker.Container{ID: "ID", Name: "name", Config: &docker.Config{}}
+
+	js, err := adapter.encode(&router.Message{Container: &container, Source: "stdout", Data: "foo", Time: time.Now()})
+	assert.Nil(err)
+
+	var data map[string]interface{}
+	assert.Nil(json.Unmarshal(js, &data))
+	assert.Equal(float64(8080), data["_port"])
+	assert.Equal(0.5, data["_ratio"])
+	assert.Equal("true", data["_on"])
+	assert.Equal("false", data["_off"])
+	assert.Equal("8080", data["_name"])
+	assert.NotContains(data, "_none")
+}
+
+func TestEncodeGELFZeroTime(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	adapter.config.encoder = encoderGELF
+	container := docker.Container{ID: "ID", Name: "name", Config: &docker.Config{}}
+
+	before := float64(time.Now().Unix())
+	js, err := adapter.encode(&router.Message{Container: &container, Source: "stdout", Data: "foo"})
+	assert.Nil(err)
+
+	var data map[string]interface{}
+	assert.Nil(json.Unmarshal(js, &data))
+	assert.True(data["timestamp"].(float64) >= before, data["timestamp"])
+}
+
+func TestGELFUDPProtocolChunks(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	conn := RecordingConn{writes: &writes}
+
+	js := bytes.Repeat([]byte("a"), 100)
+	p := &gelfUDPProtocol{compression: gelfCompressionNone, chunkSize: gelfChunkHeaderSize + 40}
+	assert.Nil(p.writeBatch(conn, [][]byte{js}))
+
+	assert.Len(writes, 3)
+	var payload []byte
+	for i, w := range writes {
+		assert.Equal([]byte{0x1e, 0x0f}, []byte(w[:2]))
+		assert.Equal(writes[0][2:10], w[2:10])
+		assert.Equal([]byte{byte(i), 3}, []byte(w[10:12]))
+		payload = append(payload, w[12:]...)
+	}
+	assert.Equal(js, payload)
+
+	// Too many chunks to send at all.
+	writes = nil
+	assert.Nil(p.writeBatch(conn, [][]byte{bytes.Repeat([]byte("a"), 40*gelfMaxChunks+1)}))
+	assert.Empty(writes)
+}
+
+func TestGELFUDPProtocolCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	js := []byte(`{"version":"1.1"}`)
+
+	var writes []string
+	p := &gelfUDPProtocol{compression: gelfCompressionGzip, chunkSize: defaultGELFChunkSize}
+	assert.Nil(p.writeBatch(RecordingConn{writes: &writes}, [][]byte{js}))
+	r, err := gzip.NewReader(bytes.NewReader([]byte(writes[0])))
+	assert.Nil(err)
+	decompressed, _ := ioutil.ReadAll(r)
+	assert.Equal(js, decompressed)
+
+	writes = nil
+	p = &gelfUDPProtocol{compression: gelfCompressionZlib, chunkSize: defaultGELFChunkSize}
+	assert.Nil(p.writeBatch(RecordingConn{writes: &writes}, [][]byte{js}))
+	zr, err := zlib.NewReader(bytes.NewReader([]byte(writes[0])))
+	assert.Nil(err)
+	decompressed, _ = ioutil.ReadAll(zr)
+	assert.Equal(js, decompressed)
+}
+
+func TestGELFOverTCPIsNullTerminated(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	p := linesProtocol{delimiter: 0}
+	assert.Nil(p.writeBatch(RecordingConn{writes: &writes}, [][]byte{[]byte(`{"version":"1.1"}`)}))
+	assert.Equal([]string{"{\"version\":\"1.1\"}\x00"}, writes)
+}
@@ -0,0 +1,58 @@
+package logstash
+
+import (
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHostHostnameFile   = "/etc/host_hostname"
+	defaultHostHostnameReload = time.Minute
+)
+
+// hostHostname holds the name of the Docker host, read from a file that is
+// usually mounted from the host. The file is read when the adapter starts and
+// again once the reload interval has passed, rather than for every event.
+type hostHostname struct {
+	path   string
+	reload time.Duration
+
+	mu       sync.Mutex
+	name     string
+	loadedAt time.Time
+}
+
+func newHostHostname(path string, reload time.Duration) *hostHostname {
+	h := &hostHostname{path: path, reload: reload}
+	h.load(time.Now())
+	return h
+}
+
+func (h *hostHostname) load(now time.Time) {
+	h.loadedAt = now
+
+	content, err := ioutil.ReadFile(h.path)
+	if err != nil {
+		h.name = ""
+		return
+	}
+	h.name = strings.Trim(string(content), " \r\n")
+}
+
+// get returns the name of the host, or an empty string if the file doesn't
+// exist or is empty.
+func (h *hostHostname) get() string {
+	if h == nil {
+		return ""
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if now := time.Now(); h.reload > 0 && now.Sub(h.loadedAt) >= h.reload {
+		h.load(now)
+	}
+	return h.name
+}
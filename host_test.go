@@ -0,0 +1,80 @@
+package logstash
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostHostnameReload(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "host")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "host_hostname")
+
+	assert.NoError(ioutil.WriteFile(path, []byte("docker-host-1\n"), 0644))
+	h := newHostHostname(path, time.Hour)
+	assert.Equal("docker-host-1", h.get())
+
+	// The file isn't read again before the reload interval has passed.
+	assert.NoError(ioutil.WriteFile(path, []byte("docker-host-2\n"), 0644))
+	assert.Equal("docker-host-1", h.get())
+
+	h.loadedAt = time.Now().Add(-time.Hour)
+	assert.Equal("docker-host-2", h.get())
+
+	assert.NoError(os.Remove(path))
+	h.loadedAt = time.Now().Add(-time.Hour)
+	assert.Equal("", h.get())
+}
+
+func TestHostHostnameMissing(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", newHostHostname("/nonexistent/host_hostname", 0).get())
+
+	var h *hostHostname
+	assert.Equal("", h.get())
+}
+
+func TestEncodeHost(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "host")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "host_hostname")
+	assert.NoError(ioutil.WriteFile(path, []byte("docker-host\n"), 0644))
+
+	container := docker.Container{ID: "ID", Name: "name", Config: &docker.Config{Hostname: "hostname"}}
+	message := router.Message{Container: &container, Source: "stdout", Data: "foo", Time: time.Now()}
+
+	for schema, host := range map[string]interface{}{
+		"logstash": "docker-host",
+		"ecs":      map[string]interface{}{"hostname": "docker-host"},
+	} {
+		adapter := newTestAdapter(MockConn{})
+		adapter.config, _ = newRouteConfig(&router.Route{Options: map[string]string{
+			"output_schema":      schema,
+			"host_hostname_file": path,
+		}})
+		adapter.host = newHostHostname(adapter.config.hostHostnameFile, adapter.config.hostHostnameReload)
+
+		js, err := adapter.encode(&message)
+		assert.NoError(err)
+
+		var data map[string]interface{}
+		assert.NoError(json.Unmarshal(js, &data))
+		assert.Equal(host, data["host"])
+		if schema == "logstash" {
+			assert.Equal("hostname", data["docker"].(map[string]interface{})["hostname"])
+		}
+	}
+}
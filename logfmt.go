@@ -0,0 +1,100 @@
+package logstash
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// decodeLogfmt decodes a logfmt line, such as level=info msg="started"
+// dur=3ms, into fields. Values are strings, quoted values are unquoted with
+// Go escapes, and a bare key without a value is true. A key can name a nested
+// field like the keys of LOGSTASH_FIELDS. It fails if the line isn't logfmt,
+// or has more bare keys than key=value pairs, so that text that merely
+// mentions a key=value pair isn't taken for bare keys.
+func decodeLogfmt(line string) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	pairs, bare := 0, 0
+
+	i := 0
+	for {
+		for i < len(line) && isLogfmtSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			break
+		}
+
+		start := i
+		for i < len(line) && !isLogfmtSpace(line[i]) && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" || i < len(line) && line[i] == '"' {
+			return nil, fmt.Errorf("unexpected %q at %d", line[i], i)
+		}
+		if i >= len(line) || isLogfmtSpace(line[i]) {
+			setField(data, fieldPath(key), true)
+			bare++
+			continue
+		}
+
+		// Skip the '='.
+		i++
+		pairs++
+
+		var value string
+		if i < len(line) && line[i] == '"' {
+			end := closingQuote(line, i)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote at %d", i)
+			}
+			var err error
+			if value, err = strconv.Unquote(line[i : end+1]); err != nil {
+				return nil, fmt.Errorf("invalid quoted value at %d: %v", i, err)
+			}
+			i = end + 1
+		} else {
+			start = i
+			for i < len(line) && !isLogfmtSpace(line[i]) {
+				if line[i] == '"' {
+					return nil, fmt.Errorf("unexpected %q at %d", line[i], i)
+				}
+				i++
+			}
+			value = line[start:i]
+		}
+		if i < len(line) && !isLogfmtSpace(line[i]) {
+			return nil, fmt.Errorf("unexpected %q at %d", line[i], i)
+		}
+
+		setField(data, fieldPath(key), value)
+	}
+
+	if pairs == 0 {
+		return nil, errors.New("no key=value pairs")
+	}
+	if bare > pairs {
+		return nil, fmt.Errorf("%d bare keys for %d key=value pairs", bare, pairs)
+	}
+	return data, nil
+}
+
+func isLogfmtSpace(c byte) bool {
+	return strings.IndexByte(" \t\r\n", c) >= 0
+}
+
+// closingQuote returns the index of the quote closing the one at start, or -1
+// if there is none.
+func closingQuote(line string, start int) int {
+	for i := start + 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,106 @@
+package logstash
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeLogfmt(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := decodeLogfmt(`level=info msg="started server" dur=3ms`)
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"level": "info", "msg": "started server", "dur": "3ms"}, data)
+
+	data, err = decodeLogfmt("  debug  err=  path=\"C:\\\\logs \\\"a\\\"\"\tline=\"a\\nb\" ")
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{"debug": true, "err": "", "path": `C:\logs "a"`, "line": "a\nb"}, data)
+
+	data, err = decodeLogfmt(`http.status=200 http.method=GET url=/a?b=c`)
+	assert.Nil(err)
+	assert.Equal(map[string]interface{}{
+		"http": map[string]interface{}{"status": "200", "method": "GET"},
+		"url":  "/a?b=c",
+	}, data)
+}
+
+func TestDecodeLogfmtInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, line := range []string{
+		"",
+		"started server on port 80",
+		"Starting HTTP server with port=8080 and 4 workers",
+		"retrying debug=true now",
+		`msg="unterminated`,
+		`msg="a"b`,
+		`msg=a"b"`,
+		`=value`,
+		`"quoted"=value`,
+		`{"message": "not logfmt"`,
+	} {
+		_, err := decodeLogfmt(line)
+		assert.NotNil(err, line)
+	}
+}
+
+func TestEncodeLogfmt(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+	container := docker.Container{ID: "ID", Name: "name", Config: &docker.Config{
+		Env: []string{"DECODE_LOGFMT_LOGS=true"},
+	}}
+	message := func(data string) map[string]interface{} {
+		js, err := adapter.encode(&router.Message{Container: &container, Source: "stdout", Data: data, Time: time.Now()})
+		assert.Nil(err)
+		var decoded map[string]interface{}
+		assert.Nil(json.Unmarshal(js, &decoded))
+		return decoded
+	}
+
+	data := message(`level=warn msg="disk full" stream=x`)
+	assert.Equal("warn", data["level"])
+	assert.Equal("disk full", data["msg"])
+	assert.Equal("stdout", data["stream"])
+	assert.Equal(`level=warn msg="disk full" stream=x`, data["message"])
+
+	data = message(`level=warn message="disk full"`)
+	assert.Equal("disk full", data["message"])
+
+	// Prose mentioning a key=value pair is sent as is.
+	data = message("Starting HTTP server with port=8080 and 4 workers")
+	assert.Equal("Starting HTTP server with port=8080 and 4 workers", data["message"])
+	assert.Nil(data["port"])
+	assert.Nil(data["Starting"])
+
+	// JSON is still decoded first.
+	data = message(`{"message": "json", "level": "error"}`)
+	assert.Equal("json", data["message"])
+	assert.Equal("error", data["level"])
+
+	data = message("plain text")
+	assert.Equal("plain text", data["message"])
+
+	// Logfmt isn't decoded by default.
+	container.ID = "other"
+	container.Config.Env = nil
+	data = message(`level=info`)
+	assert.Equal("level=info", data["message"])
+	assert.Nil(data["level"])
+
+	adapter.config.decodeLogfmt = "true"
+	container.ID = "default"
+	data = message(`level=info`)
+	assert.Equal("info", data["level"])
+
+	container.ID = "disabled"
+	container.Config.Env = []string{"DECODE_LOGFMT_LOGS=false"}
+	data = message(`level=info`)
+	assert.Equal("level=info", data["message"])
+}
@@ -3,12 +3,11 @@ package logstash
 import (
 	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"log"
 	"net"
-	"os"
-	"regexp"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
@@ -21,33 +20,130 @@ func init() {
 
 // LogstashAdapter is an adapter that streams UDP JSON to Logstash.
 type LogstashAdapter struct {
-	conn           net.Conn
-	route          *router.Route
-	containerTags  map[string][]string
-	logstashFields map[string]map[string]string
-	decodeJsonLogs map[string]bool
+	addresses    []string
+	endpoints    []*endpoint
+	nextEndpoint int
+	resolver     resolver
+	refreshAt    time.Time
+	transport    router.AdapterTransport
+	spool        *spool
+	protocol     protocol
+	batch        batchLimits
+	maxEventSize int
+	route        *router.Route
+	config       *routeConfig
+	containers   *containerCache
+	host         *hostHostname
+	metrics      *routeMetrics
+	shutdown     *shutdown
 }
 
 // NewLogstashAdapter creates a LogstashAdapter with UDP as the default transport.
 func NewLogstashAdapter(route *router.Route) (router.LogAdapter, error) {
-	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("udp"))
-	if !found {
-		return nil, errors.New("unable to find adapter: " + route.Adapter)
+	config, err := newRouteConfig(route)
+	if err != nil {
+		return nil, err
 	}
 
-	for {
-		conn, err := transport.Dial(route.Address, route.Options)
+	var proto protocol = linesProtocol{delimiter: '\n'}
+	batch := batchLimits{
+		maxEvents: config.batchMaxEvents,
+		maxBytes:  config.batchMaxBytes,
+		linger:    config.batchLinger,
+	}
+
+	// The beats protocol runs over a plain TCP connection.
+	transportName := route.AdapterTransport("udp")
+	if transportName == "beats" && config.encoder == encoderGELF {
+		return nil, errors.New("logstash: the gelf encoder can't be used with beats")
+	}
+	if config.compression != compressionNone && (transportName == "udp" || transportName == "beats") {
+		return nil, errors.New("logstash: compression needs the tcp or tls transport")
+	}
+	maxEventSize := 0
+	if transportName == "udp" {
+		proto = linesProtocol{delimiter: '\n', maxPayload: config.udpMaxPayload}
+		maxEventSize = config.udpMaxPayload
+	}
+	if config.encoder == encoderGELF {
+		// GELF over UDP is chunked instead.
+		maxEventSize = 0
+		proto = linesProtocol{delimiter: 0}
+		if transportName == "udp" {
+			proto = &gelfUDPProtocol{compression: config.gelfCompression, chunkSize: config.gelfChunkSize}
+		}
+	}
+	if transportName == "beats" {
+		proto = &beatsProtocol{
+			windowSize:       config.beatsWindowSize,
+			compressionLevel: config.beatsCompressionLevel,
+			timeout:          config.beatsTimeout,
+		}
+		batch = batchLimits{maxEvents: config.beatsWindowSize}
+		transportName = "tcp"
+	}
 
+	transport, err := newTransport(route, transportName, config)
+	if err != nil {
+		return nil, err
+	}
+	if config.compression != compressionNone {
+		transport = &compressedTransport{
+			AdapterTransport: transport,
+			compression:      config.compression,
+			level:            config.compressionLevel,
+		}
+	}
+
+	spool, err := newSpool(route, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.metricsAddr != "" {
+		if err := registeredMetrics.serve(config.metricsAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	containers := newContainerCache(config.containerCacheSize, config.containerCacheTTL)
+	metrics := newRouteMetrics(route, containers)
+
+	a := &LogstashAdapter{
+		route:        route,
+		config:       config,
+		addresses:    endpointAddresses(route.Address, config),
+		resolver:     net.DefaultResolver,
+		transport:    transport,
+		spool:        spool,
+		protocol:     proto,
+		batch:        batch,
+		maxEventSize: maxEventSize,
+		containers:   containers,
+		metrics:      metrics,
+		host:         newHostHostname(config.hostHostnameFile, config.hostHostnameReload),
+		shutdown:     newShutdown(),
+	}
+
+	for {
+		err := a.resolveEndpoints()
 		if err == nil {
-			return &LogstashAdapter{
-				route:          route,
-				conn:           conn,
-				containerTags:  make(map[string][]string),
-				logstashFields: make(map[string]map[string]string),
-				decodeJsonLogs: make(map[string]bool),
-			}, nil
-		}
-		if os.Getenv("RETRY_STARTUP") == "" {
+			err = a.connect()
+		}
+
+		// With a spool, events are kept on disk until Logstash is reachable.
+		if err != nil && spool != nil {
+			log.Println("logstash: could not connect, spooling events:", err)
+		}
+
+		if err == nil || spool != nil {
+			registeredMetrics.register(metrics)
+			if config.shutdownTimeout > 0 {
+				closeOnSignal(a)
+			}
+			return a, nil
+		}
+		if !config.retryStartup {
 			return nil, err
 		}
 		log.Println("Retrying:", err)
@@ -55,14 +151,14 @@ func NewLogstashAdapter(route *router.Route) (router.LogAdapter, error) {
 	}
 }
 
-// Get container tags configured with the environment variable LOGSTASH_TAGS
+// Get container tags configured with the setting LOGSTASH_TAGS
 func GetContainerTags(c *docker.Container, a *LogstashAdapter) []string {
-	if tags, ok := a.containerTags[c.ID]; ok {
-		return tags
+	if tags, ok := a.containers.get(c.ID, "tags"); ok {
+		return tags.([]string)
 	}
 
 	tags := []string{}
-	tagsStr := os.Getenv("LOGSTASH_TAGS")
+	tagsStr := a.config.logstashTags
 
 	for _, e := range c.Config.Env {
 		if strings.HasPrefix(e, "LOGSTASH_TAGS=") {
@@ -75,18 +171,18 @@ func GetContainerTags(c *docker.Container, a *LogstashAdapter) []string {
 		tags = strings.Split(tagsStr, ",")
 	}
 
-	a.containerTags[c.ID] = tags
+	a.containers.set(c.ID, "tags", tags)
 	return tags
 }
 
-// Get logstash fields configured with the environment variable LOGSTASH_FIELDS
-func GetLogstashFields(c *docker.Container, a *LogstashAdapter) map[string]string {
-	if fields, ok := a.logstashFields[c.ID]; ok {
-		return fields
+// Get logstash fields configured with the setting LOGSTASH_FIELDS
+func GetLogstashFields(c *docker.Container, a *LogstashAdapter) map[string]interface{} {
+	if fields, ok := a.containers.get(c.ID, "fields"); ok {
+		return fields.(map[string]interface{})
 	}
 
-	fieldsStr := os.Getenv("LOGSTASH_FIELDS")
-	fields := map[string]string{}
+	fieldsStr := a.config.logstashFields
+	fields := map[string]interface{}{}
 
 	for _, e := range c.Config.Env {
 		if strings.HasPrefix(e, "LOGSTASH_FIELDS=") {
@@ -94,27 +190,27 @@ func GetLogstashFields(c *docker.Container, a *LogstashAdapter) map[string]strin
 		}
 	}
 
-	if len(fieldsStr) > 0 {
-		for _, f := range strings.Split(fieldsStr, ",") {
-			sp := strings.Split(f, "=")
-			k, v := sp[0], sp[1]
-			fields[k] = v
-		}
+	parsed, errs := ParseLogstashFields(fieldsStr, a.config.logstashFieldsTyped)
+	for _, err := range errs {
+		log.Println("logstash: LOGSTASH_FIELDS of container", c.Name+":", err)
+	}
+	for _, f := range parsed {
+		setField(fields, fieldPath(f.key), f.value)
 	}
 
-	a.logstashFields[c.ID] = fields
+	a.containers.set(c.ID, "fields", fields)
 
 	return fields
 }
 
 // Get boolean indicating whether json logs should be decoded (or added as message),
-// configured with the environment variable DECODE_JSON_LOGS
+// configured with the setting DECODE_JSON_LOGS
 func IsDecodeJsonLogs(c *docker.Container, a *LogstashAdapter) bool {
-	if decodeJsonLogs, ok := a.decodeJsonLogs[c.ID]; ok {
-		return decodeJsonLogs
+	if decodeJsonLogs, ok := a.containers.get(c.ID, "decodeJsonLogs"); ok {
+		return decodeJsonLogs.(bool)
 	}
 
-	decodeJsonLogsStr := os.Getenv("DECODE_JSON_LOGS")
+	decodeJsonLogsStr := a.config.decodeJsonLogs
 
 	for _, e := range c.Config.Env {
 		if strings.HasPrefix(e, "DECODE_JSON_LOGS=") {
@@ -124,126 +220,295 @@ func IsDecodeJsonLogs(c *docker.Container, a *LogstashAdapter) bool {
 
 	decodeJsonLogs := decodeJsonLogsStr != "false"
 
-	a.decodeJsonLogs[c.ID] = decodeJsonLogs
+	a.containers.set(c.ID, "decodeJsonLogs", decodeJsonLogs)
 
 	return decodeJsonLogs
 }
 
-// Get hostname of container, searching first for /etc/host_hostname, otherwise
-// using the hostname assigned to the container (typically container ID).
-func GetContainerHostname(c *docker.Container) string {
-	content, err := ioutil.ReadFile("/etc/host_hostname")
-	if err == nil && len(content) > 0 {
-		return strings.Trim(string(content), "\r\n")
+// Get boolean indicating whether logfmt logs should be decoded, configured
+// with the setting DECODE_LOGFMT_LOGS. Unlike JSON logs they aren't decoded
+// unless it is set to true.
+func IsDecodeLogfmtLogs(c *docker.Container, a *LogstashAdapter) bool {
+	if decodeLogfmt, ok := a.containers.get(c.ID, "decodeLogfmtLogs"); ok {
+		return decodeLogfmt.(bool)
 	}
 
-	return c.Config.Hostname
+	decodeLogfmtStr := a.config.decodeLogfmt
+
+	for _, e := range c.Config.Env {
+		if strings.HasPrefix(e, "DECODE_LOGFMT_LOGS=") {
+			decodeLogfmtStr = strings.TrimPrefix(e, "DECODE_LOGFMT_LOGS=")
+		}
+	}
+
+	decodeLogfmt := decodeLogfmtStr == "true"
+
+	a.containers.set(c.ID, "decodeLogfmtLogs", decodeLogfmt)
+
+	return decodeLogfmt
 }
 
-// Stream implements the router.LogAdapter interface.
-func (a *LogstashAdapter) Stream(logstream chan *router.Message) {
+// Get the labels of a container, with dots in their names replaced by
+// underscores, or as nested objects split at the dots if nested is true.
+// Labels are set in order of their names, so a label like a.b replaces a
+// label a.
+func GetContainerLabels(c *docker.Container, nested bool) map[string]interface{} {
+	labels := make(map[string]interface{})
 
-	for m := range logstream {
+	names := make([]string, 0, len(c.Config.Labels))
+	for name := range c.Config.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-		dockerInfo := DockerInfo{
-			Name:     m.Container.Name,
-			ID:       m.Container.ID,
-			Image:    m.Container.Config.Image,
-			Hostname: GetContainerHostname(m.Container),
+	for _, name := range names {
+		if nested {
+			setField(labels, fieldPath(name), c.Config.Labels[name])
+		} else {
+			labels[strings.Replace(name, ".", "_", -1)] = c.Config.Labels[name]
 		}
+	}
 
-		// Check if we are sending logs for this container
-		if !containerIncluded(dockerInfo.Name) {
-			continue
-		}
+	return labels
+}
+
+// Get hostname of container, which is the hostname assigned to the container
+// (typically the container ID).
+func GetContainerHostname(c *docker.Container) string {
+	return c.Config.Hostname
+}
+
+// Get hostname of the Docker host, read from HOST_HOSTNAME_FILE, or else the
+// hostname of container.
+func GetHostHostname(c *docker.Container, a *LogstashAdapter) string {
+	if name := a.host.get(); name != "" {
+		return name
+	}
+	return GetContainerHostname(c)
+}
+
+// eventTime returns the time of an event logged at t, which is the current
+// time if t is unknown.
+func eventTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
+
+// FormatTimestamp formats t as RFC3339 in UTC, with the fractional seconds
+// limited to precision (s, ms, us or ns)
+func FormatTimestamp(t time.Time, precision string) string {
+	t = eventTime(t)
+
+	layout := time.RFC3339Nano
+	switch precision {
+	case "s":
+		layout = "2006-01-02T15:04:05Z07:00"
+	case "ms":
+		layout = "2006-01-02T15:04:05.000Z07:00"
+	case "us":
+		layout = "2006-01-02T15:04:05.000000Z07:00"
+	}
+
+	return t.UTC().Format(layout)
+}
+
+// newTransport returns the transport of route named name. A tls route with TLS
+// settings of its own uses them instead of the generic tls transport, which
+// is configured by the LOGSPOUT_TLS_* variables of logspout.
+func newTransport(route *router.Route, name string, config *routeConfig) (router.AdapterTransport, error) {
+	if name == "tls" && config.tlsConfigured() {
+		return newTLSTransport(config)
+	}
 
-		if os.Getenv("DOCKER_LABELS") != "" {
-			dockerInfo.Labels = make(map[string]string)
-			for label, value := range m.Container.Config.Labels {
-				dockerInfo.Labels[strings.Replace(label, ".", "_", -1)] = value
+	transport, found := router.AdapterTransports.Lookup(name)
+	if !found {
+		return nil, errors.New("unable to find adapter: " + route.Adapter)
+	}
+	return transport, nil
+}
+
+// Stream implements the router.LogAdapter interface. Messages are encoded
+// here and sent by a separate goroutine, so a slow Logstash only fills the
+// queue instead of stalling the router.
+//
+// Stream returns when logstream is closed or the adapter is closed, once the
+// events it received are sent and the connection is closed.
+func (a *LogstashAdapter) Stream(logstream chan *router.Message) {
+	a.shutdown.streams.RLock()
+	defer a.shutdown.streams.RUnlock()
+
+	queue := newEventQueue(a.config.queueSize, a.config.queueOverflow)
+	a.metrics.setQueue(queue)
+	sent := make(chan struct{})
+
+	go func() {
+		if a.spool != nil {
+			a.sendSpooled(queue)
+		} else {
+			for js, ok := queue.next(); ok; js, ok = queue.next() {
+				events := queue.batch(js, a.batch)
+				if !a.write(events) {
+					atomic.AddUint64(&a.metrics.shutdownDropped, uint64(len(events)))
+				}
 			}
 		}
+		a.closeConns()
+		close(sent)
+	}()
 
-		tags := GetContainerTags(m.Container, a)
-		fields := GetLogstashFields(m.Container, a)
+	lines := newMultilineAggregator()
+	ticker := time.NewTicker(multilineTickInterval)
 
-		var js []byte
-		var data map[string]interface{}
-		var err error
+	defer func() {
+		ticker.Stop()
+		for _, m := range lines.flushAll() {
+			a.enqueue(queue, m)
+		}
+		queue.close()
+		<-sent
+	}()
 
-		// Try to parse JSON-encoded m.Data. If it wasn't JSON, create an empty object
-		// and use the original data as the message.
-		if IsDecodeJsonLogs(m.Container, a) {
-			err = json.Unmarshal([]byte(m.Data), &data)
+	receive := func(m *router.Message) {
+		// Check if we are sending logs for this container
+		if !IsContainerIncluded(m.Container, a) {
+			return
 		}
-		if err != nil || data == nil {
-			data = make(map[string]interface{})
-			data["message"] = m.Data
+		a.metrics.containerEvent(m.Container)
+
+		for _, m := range lines.add(m, GetMultilineConfig(m.Container, a)) {
+			a.enqueue(queue, m)
 		}
+	}
 
-		for k, v := range fields {
-			data[k] = v
+	for {
+		select {
+		case m, ok := <-logstream:
+			if !ok {
+				return
+			}
+			receive(m)
+		case now := <-ticker.C:
+			for _, m := range lines.flushExpired(now) {
+				a.enqueue(queue, m)
+			}
+		case <-a.shutdown.closing:
+			// Take the messages the router already handed over.
+			for {
+				select {
+				case m, ok := <-logstream:
+					if !ok {
+						return
+					}
+					receive(m)
+				default:
+					return
+				}
+			}
 		}
+	}
+}
 
-		data["docker"] = dockerInfo
-		data["stream"] = m.Source
-		data["tags"] = tags
+// enqueue encodes m and adds it to queue.
+func (a *LogstashAdapter) enqueue(queue *eventQueue, m *router.Message) {
+	js, err := a.encode(m)
+	if err != nil {
+		// Log error message and continue parsing next line, if marshalling fails
+		log.Println("logstash: could not encode event:", err)
+		atomic.AddUint64(&a.metrics.encodeErrors, 1)
+		return
+	}
+
+	events := [][]byte{js}
+	if a.maxEventSize > 0 && len(js)+1 > a.maxEventSize {
+		events = a.fitEvent(js)
+	}
 
-		// Return the JSON encoding
-		if js, err = json.Marshal(data); err != nil {
-			// Log error message and continue parsing next line, if marshalling fails
-			log.Println("logstash: could not marshal JSON:", err)
-			continue
+	for _, js := range events {
+		if dropped := queue.push(js); dropped > 0 {
+			atomic.AddUint64(&a.metrics.queueDropped, uint64(dropped))
 		}
+	}
+}
+
+// encode returns the JSON or GELF encoding of the event for m.
+func (a *LogstashAdapter) encode(m *router.Message) ([]byte, error) {
+	dockerInfo := DockerInfo{
+		Name:     m.Container.Name,
+		ID:       m.Container.ID,
+		Image:    m.Container.Config.Image,
+		Hostname: GetContainerHostname(m.Container),
+	}
+
+	if a.config.dockerLabels {
+		dockerInfo.Labels = GetContainerLabels(m.Container, a.config.dockerLabelsNested)
+	}
 
-		// To work with tls and tcp transports via json_lines codec
-		js = append(js, byte('\n'))
+	tags := GetContainerTags(m.Container, a)
+	fields := GetLogstashFields(m.Container, a)
 
-		for {
-			_, err := a.conn.Write(js)
+	var data map[string]interface{}
+	var err error
 
-			if err == nil {
-				break
-			}
+	timestampField := a.config.timestampField
 
-			if os.Getenv("RETRY_SEND") == "" {
-				log.Fatal("logstash: could not write:", err)
-			} else {
-				time.Sleep(2 * time.Second)
+	// Try to parse JSON-encoded m.Data, and else logfmt. If it was neither,
+	// create an empty object and use the original data as the message.
+	if IsDecodeJsonLogs(m.Container, a) {
+		err = json.Unmarshal([]byte(m.Data), &data)
+	}
+	if (err != nil || data == nil) && IsDecodeLogfmtLogs(m.Container, a) {
+		// Logfmt lines usually have no message field, keep the line as
+		// the message so that nothing is lost.
+		if data, err = decodeLogfmt(m.Data); err == nil {
+			if _, ok := data["message"]; !ok {
+				data["message"] = m.Data
 			}
 		}
 	}
-}
+	if err != nil || data == nil {
+		data = make(map[string]interface{})
+		data["message"] = m.Data
+	}
 
-// containerIncluded Returns true if this container is in INCLUDE_CONTAINERS, or not env var is set
-func containerIncluded(inputContainerName string) bool {
-	if includeContainers := os.Getenv("INCLUDE_CONTAINERS"); includeContainers != "" {
-		for _, containerName := range strings.Split(includeContainers, ",") {
-			if inputContainerName == containerName {
-				// This contain is included, send this log
-				return true
-			}
-		}
-		return false
-	} else if includeContainers := os.Getenv("INCLUDE_CONTAINERS_REGEX"); includeContainers != "" {
-		compiled, err := regexp.Compile(includeContainers)
-		if err != nil {
-			// Return true by default
-			return true
-		}
-		if compiled.MatchString(inputContainerName) {
-			// This contain is included, send this log
-			return true
-		}
-		return false
+	// Keep the timestamp of a decoded payload only if asked to, Logstash
+	// would otherwise fail to parse it or silently replace it.
+	if _, ok := data[timestampField]; !ok || !a.config.keepJSONTimestamp {
+		data[timestampField] = FormatTimestamp(m.Time, a.config.timestampPrecision)
 	}
-	return true
+	data["@version"] = "1"
+
+	// Fields are merged into the decoded payload, replacing its values. The
+	// fields describing the container are set last, and replace the docker,
+	// host, stream and tags of both, while the ECS objects are merged into
+	// them.
+	mergeFields(data, fields)
+
+	host := GetHostHostname(m.Container, a)
+
+	switch a.config.outputSchema {
+	case schemaECS:
+		mergeFields(data, ecsFields(dockerInfo, host, m))
+	default:
+		data["docker"] = dockerInfo
+		data["host"] = host
+		data["stream"] = m.Source
+	}
+	data["tags"] = tags
+
+	if a.config.encoder == encoderGELF {
+		return encodeGELF(data, m, host, timestampField)
+	}
+
+	// Return the JSON encoding
+	return json.Marshal(data)
 }
 
 type DockerInfo struct {
-	Name     string            `json:"name"`
-	ID       string            `json:"id"`
-	Image    string            `json:"image"`
-	Hostname string            `json:"hostname"`
-	Labels   map[string]string `json:"labels"`
+	Name     string                 `json:"name"`
+	ID       string                 `json:"id"`
+	Image    string                 `json:"image"`
+	Hostname string                 `json:"hostname"`
+	Labels   map[string]interface{} `json:"labels"`
 }
@@ -50,18 +50,30 @@ func (m MockConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
+func newTestAdapter(conn net.Conn) *LogstashAdapter {
+	route := new(router.Route)
+	config, _ := newRouteConfig(route)
+	containers := newContainerCache(config.containerCacheSize, config.containerCacheTTL)
+
+	return &LogstashAdapter{
+		route:      route,
+		config:     config,
+		endpoints:  []*endpoint{{address: route.Address, conn: conn, connected: conn != nil}},
+		protocol:   linesProtocol{delimiter: '\n'},
+		batch:      batchLimits{maxEvents: 1},
+		containers: containers,
+		host:       newHostHostname(config.hostHostnameFile, config.hostHostnameReload),
+		metrics:    newRouteMetrics(route, containers),
+		shutdown:   newShutdown(),
+	}
+}
+
 func TestStreamNullData(t *testing.T) {
 	assert := assert.New(t)
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -113,13 +125,7 @@ func TestStreamNotJsonWithoutLogstashTags(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -171,13 +177,7 @@ func TestStreamNotJsonWithLogstashTags(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -229,13 +229,7 @@ func TestStreamJsonWithoutLogstashTags(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -293,13 +287,7 @@ func TestStreamJsonWithLogstashTags(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -357,13 +345,7 @@ func TestStreamNotJsonWithLogstashFields(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -417,13 +399,7 @@ func TestStreamJsonWithLogstashFields(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -485,13 +461,7 @@ func TestStreamNotJsonWithLogstashFieldsWithDefault(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -547,13 +517,7 @@ func TestStreamJsonWithLogstashFieldsWithDefault(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -615,13 +579,7 @@ func TestStreamNotJsonWithLogstashTagsWithDefault(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -675,13 +633,7 @@ func TestStreamJsonWithLogstashTagsWithDefault(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -739,13 +691,7 @@ func TestStreamJsonWithLogstashFieldsAndBlacklist(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -808,13 +754,7 @@ func TestStreamJsonWithLogstashFieldsWithDefaultAndBlacklist(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -878,13 +818,7 @@ func TestStreamJsonLabelsDisabled(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -948,13 +882,7 @@ func TestStreamJsonLabelsEnabled(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -1025,13 +953,7 @@ func TestStreamJsonLabelsEnabledButEmpty(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -1096,13 +1018,7 @@ func TestStreamJsonWithDecodeJsonLogsFalse(t *testing.T) {
 
 	conn := MockConn{}
 
-	adapter := LogstashAdapter{
-		route:          new(router.Route),
-		conn:           conn,
-		containerTags:  make(map[string][]string),
-		logstashFields: make(map[string]map[string]string),
-		decodeJsonLogs: make(map[string]bool),
-	}
+	adapter := newTestAdapter(conn)
 
 	assert.NotNil(adapter)
 
@@ -1148,3 +1064,164 @@ func TestStreamJsonWithDecodeJsonLogsFalse(t *testing.T) {
 	assert.Equal("image", dockerInfo["image"])
 	assert.Equal("hostname", dockerInfo["hostname"])
 }
+
+func TestStreamTimestamp(t *testing.T) {
+	assert := assert.New(t)
+
+	conn := MockConn{}
+
+	adapter := newTestAdapter(conn)
+
+	logstream := make(chan *router.Message)
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	message := router.Message{
+		Container: &container,
+		Source:    "FOOOOO",
+		Data:      `{ "@timestamp": "2017-01-01T00:00:00Z" }`,
+		Time:      time.Date(2018, 3, 4, 5, 6, 7, 123456789, time.FixedZone("CET", 3600)),
+	}
+
+	go func() {
+		logstream <- &message
+		close(logstream)
+	}()
+
+	adapter.Stream(logstream)
+
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(res), &data)
+	assert.Nil(err)
+
+	assert.Equal("2018-03-04T04:06:07.123456789Z", data["@timestamp"])
+	assert.Equal("1", data["@version"])
+}
+
+func TestStreamTimestampFieldAndPrecision(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("TIMESTAMP_FIELD", "time")
+	os.Setenv("TIMESTAMP_PRECISION", "ms")
+	defer os.Unsetenv("TIMESTAMP_FIELD")
+	defer os.Unsetenv("TIMESTAMP_PRECISION")
+
+	conn := MockConn{}
+
+	adapter := newTestAdapter(conn)
+
+	logstream := make(chan *router.Message)
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	message := router.Message{
+		Container: &container,
+		Source:    "FOOOOO",
+		Data:      `foo bananas`,
+		Time:      time.Date(2018, 3, 4, 5, 6, 7, 123456789, time.UTC),
+	}
+
+	go func() {
+		logstream <- &message
+		close(logstream)
+	}()
+
+	adapter.Stream(logstream)
+
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(res), &data)
+	assert.Nil(err)
+
+	assert.Equal("2018-03-04T05:06:07.123Z", data["time"])
+	assert.Nil(data["@timestamp"])
+}
+
+func TestStreamKeepJsonTimestamp(t *testing.T) {
+	assert := assert.New(t)
+
+	os.Setenv("KEEP_JSON_TIMESTAMP", "true")
+	defer os.Unsetenv("KEEP_JSON_TIMESTAMP")
+
+	conn := MockConn{}
+
+	adapter := newTestAdapter(conn)
+
+	logstream := make(chan *router.Message)
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	message := router.Message{
+		Container: &container,
+		Source:    "FOOOOO",
+		Data:      `{ "@timestamp": "2017-01-01T00:00:00Z" }`,
+		Time:      time.Now(),
+	}
+
+	go func() {
+		logstream <- &message
+		close(logstream)
+	}()
+
+	adapter.Stream(logstream)
+
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(res), &data)
+	assert.Nil(err)
+
+	assert.Equal("2017-01-01T00:00:00Z", data["@timestamp"])
+	assert.Equal("1", data["@version"])
+}
+
+func benchmarkStream(b *testing.B, data string) {
+	adapter := newTestAdapter(MockConn{})
+	adapter.config.logstashFields = "service.name=api,environment=prod"
+	adapter.config.logstashTags = "a,b"
+
+	container := docker.Container{ID: "ID", Name: "name", Config: &docker.Config{
+		Image:    "image",
+		Hostname: "hostname",
+		Labels:   map[string]string{"a.label": "yes"},
+	}}
+	message := router.Message{Container: &container, Source: "stdout", Data: data, Time: time.Now()}
+
+	logstream := make(chan *router.Message, 1024)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			logstream <- &message
+		}
+		close(logstream)
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	adapter.Stream(logstream)
+}
+
+func BenchmarkStreamPlain(b *testing.B) {
+	benchmarkStream(b, "GET /index.html 200 0.012")
+}
+
+func BenchmarkStreamJson(b *testing.B) {
+	benchmarkStream(b, `{"remote_user": "-", "status": "200", "request_method": "GET", "request_time": "0.012"}`)
+}
@@ -0,0 +1,237 @@
+package logstash
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var sendDurationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30}
+
+// routeMetrics counts what the adapter of a route does. The counters are
+// updated atomically and come first to keep them aligned for it.
+type routeMetrics struct {
+	eventsSent      uint64
+	bytesSent       uint64
+	encodeErrors    uint64
+	writeErrors     uint64
+	reconnects      uint64
+	queueDropped    uint64
+	oversizeDropped uint64
+	shutdownDropped uint64
+
+	route      string
+	containers *containerCache
+
+	mu             sync.Mutex
+	queue          *eventQueue
+	sendBuckets    []uint64
+	sendCount      uint64
+	sendSumSeconds float64
+}
+
+// containerEvents counts the events of a container. It is kept in the
+// container cache, so it goes away with the container.
+type containerEvents struct {
+	name   string
+	events uint64
+}
+
+func newRouteMetrics(route *router.Route, containers *containerCache) *routeMetrics {
+	id := route.ID
+	if id == "" {
+		id = route.Adapter + "://" + route.Address
+	}
+
+	return &routeMetrics{
+		route:       id,
+		containers:  containers,
+		sendBuckets: make([]uint64, len(sendDurationBuckets)),
+	}
+}
+
+func (m *routeMetrics) setQueue(queue *eventQueue) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queue = queue
+}
+
+func (m *routeMetrics) queueDepth() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.queue == nil {
+		return 0
+	}
+	return len(m.queue.events)
+}
+
+// sent records that events were written in d.
+func (m *routeMetrics) sent(events [][]byte, d time.Duration) {
+	var bytes int
+	for _, js := range events {
+		bytes += len(js)
+	}
+	atomic.AddUint64(&m.eventsSent, uint64(len(events)))
+	atomic.AddUint64(&m.bytesSent, uint64(bytes))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, le := range sendDurationBuckets {
+		if d.Seconds() <= le {
+			m.sendBuckets[i]++
+		}
+	}
+	m.sendCount++
+	m.sendSumSeconds += d.Seconds()
+}
+
+// containerEvent counts an event of c.
+func (m *routeMetrics) containerEvent(c *docker.Container) {
+	counter, ok := m.containers.get(c.ID, "events")
+	if !ok {
+		counter = &containerEvents{name: c.Name}
+		m.containers.set(c.ID, "events", counter)
+	}
+	atomic.AddUint64(&counter.(*containerEvents).events, 1)
+}
+
+// metricsRegistry holds the metrics of every route, and serves them in the
+// Prometheus text format.
+type metricsRegistry struct {
+	mu      sync.Mutex
+	routes  map[string]*routeMetrics
+	servers map[string]bool
+}
+
+var registeredMetrics = &metricsRegistry{
+	routes:  make(map[string]*routeMetrics),
+	servers: make(map[string]bool),
+}
+
+// register adds the metrics of a route, replacing those of an earlier
+// adapter of the same route.
+func (r *metricsRegistry) register(m *routeMetrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes[m.route] = m
+}
+
+// serve starts serving the metrics on addr, unless a route already did.
+func (r *metricsRegistry) serve(addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.servers[addr] {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	r.servers[addr] = true
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	go func() {
+		log.Println("logstash: metrics server stopped:", http.Serve(l, mux))
+	}()
+	return nil
+}
+
+func (r *metricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.write(w)
+}
+
+// write writes the metrics of every route, in order of route.
+func (r *metricsRegistry) write(w io.Writer) {
+	r.mu.Lock()
+	routes := make([]*routeMetrics, 0, len(r.routes))
+	for _, m := range r.routes {
+		routes = append(routes, m)
+	}
+	r.mu.Unlock()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].route < routes[j].route })
+
+	counter := func(name, help string, value func(m *routeMetrics) uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, m := range routes {
+			fmt.Fprintf(w, "%s{route=%s} %d\n", name, labelValue(m.route), value(m))
+		}
+	}
+
+	counter("logstash_events_sent_total", "Events written to Logstash.",
+		func(m *routeMetrics) uint64 { return atomic.LoadUint64(&m.eventsSent) })
+	counter("logstash_bytes_sent_total", "Bytes of encoded events written to Logstash.",
+		func(m *routeMetrics) uint64 { return atomic.LoadUint64(&m.bytesSent) })
+	counter("logstash_encode_errors_total", "Events that could not be encoded.",
+		func(m *routeMetrics) uint64 { return atomic.LoadUint64(&m.encodeErrors) })
+	counter("logstash_write_errors_total", "Failed writes to Logstash.",
+		func(m *routeMetrics) uint64 { return atomic.LoadUint64(&m.writeErrors) })
+	counter("logstash_reconnects_total", "Connections re-established to Logstash.",
+		func(m *routeMetrics) uint64 { return atomic.LoadUint64(&m.reconnects) })
+
+	name := "logstash_events_dropped_total"
+	fmt.Fprintf(w, "# HELP %s Events dropped instead of being sent.\n# TYPE %s counter\n", name, name)
+	for _, m := range routes {
+		fmt.Fprintf(w, "%s{route=%s,reason=\"queue_full\"} %d\n", name, labelValue(m.route), atomic.LoadUint64(&m.queueDropped))
+		fmt.Fprintf(w, "%s{route=%s,reason=\"oversize\"} %d\n", name, labelValue(m.route), atomic.LoadUint64(&m.oversizeDropped))
+		fmt.Fprintf(w, "%s{route=%s,reason=\"shutdown\"} %d\n", name, labelValue(m.route), atomic.LoadUint64(&m.shutdownDropped))
+	}
+
+	name = "logstash_queue_events"
+	fmt.Fprintf(w, "# HELP %s Events waiting in the queue.\n# TYPE %s gauge\n", name, name)
+	for _, m := range routes {
+		fmt.Fprintf(w, "%s{route=%s} %d\n", name, labelValue(m.route), m.queueDepth())
+	}
+
+	name = "logstash_container_events_total"
+	fmt.Fprintf(w, "# HELP %s Events received per container.\n# TYPE %s counter\n", name, name)
+	for _, m := range routes {
+		var lines []string
+		m.containers.each("events", func(id string, value interface{}) {
+			c := value.(*containerEvents)
+			lines = append(lines, fmt.Sprintf("%s{route=%s,container_id=%s,container_name=%s} %d\n",
+				name, labelValue(m.route), labelValue(id), labelValue(c.name), atomic.LoadUint64(&c.events)))
+		})
+		sort.Strings(lines)
+		io.WriteString(w, strings.Join(lines, ""))
+	}
+
+	name = "logstash_send_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Time taken to write a batch to Logstash.\n# TYPE %s histogram\n", name, name)
+	for _, m := range routes {
+		route := labelValue(m.route)
+
+		m.mu.Lock()
+		for i, le := range sendDurationBuckets {
+			fmt.Fprintf(w, "%s_bucket{route=%s,le=\"%g\"} %d\n", name, route, le, m.sendBuckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{route=%s,le=\"+Inf\"} %d\n", name, route, m.sendCount)
+		fmt.Fprintf(w, "%s_sum{route=%s} %g\n", name, route, m.sendSumSeconds)
+		fmt.Fprintf(w, "%s_count{route=%s} %d\n", name, route, m.sendCount)
+		m.mu.Unlock()
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns s quoted as a Prometheus label value.
+func labelValue(s string) string {
+	return `"` + labelValueReplacer.Replace(s) + `"`
+}
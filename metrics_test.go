@@ -0,0 +1,59 @@
+package logstash
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	adapter := newTestAdapter(RecordingConn{writes: &writes})
+	adapter.route.ID = "route1"
+	adapter.metrics = newRouteMetrics(adapter.route, adapter.containers)
+
+	container := docker.Container{ID: "ID", Name: "/web", Config: &docker.Config{}}
+	logstream := make(chan *router.Message)
+	go func() {
+		logstream <- &router.Message{Container: &container, Source: "stdout", Data: "first", Time: time.Now()}
+		logstream <- &router.Message{Container: &container, Source: "stdout", Data: "second", Time: time.Now()}
+		close(logstream)
+	}()
+	adapter.Stream(logstream)
+	assert.Len(writes, 2)
+
+	registry := &metricsRegistry{routes: make(map[string]*routeMetrics)}
+	registry.register(adapter.metrics)
+
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	assert.Contains(body, "# TYPE logstash_events_sent_total counter\n")
+	assert.Contains(body, `logstash_events_sent_total{route="route1"} 2`+"\n")
+	assert.Contains(body, `logstash_write_errors_total{route="route1"} 0`+"\n")
+	assert.Contains(body, `logstash_events_dropped_total{route="route1",reason="queue_full"} 0`+"\n")
+	assert.Contains(body, `logstash_queue_events{route="route1"} 0`+"\n")
+	assert.Contains(body, `logstash_container_events_total{route="route1",container_id="ID",container_name="/web"} 2`+"\n")
+	assert.Contains(body, `logstash_send_duration_seconds_bucket{route="route1",le="+Inf"} 2`+"\n")
+	assert.Contains(body, `logstash_send_duration_seconds_count{route="route1"} 2`+"\n")
+}
+
+func TestMetricsRouteID(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newRouteMetrics(&router.Route{Adapter: "logstash+tcp", Address: "logstash:5000"}, nil)
+	assert.Equal("logstash+tcp://logstash:5000", m.route)
+}
+
+func TestMetricsLabelValue(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`"a\"b\\c\nd"`, labelValue("a\"b\\c\nd"))
+}
@@ -0,0 +1,207 @@
+package logstash
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultMultilineMaxLines     = 500
+	defaultMultilineMaxBytes     = 1 << 20
+	defaultMultilineFlushTimeout = time.Second
+
+	multilineTickInterval = 100 * time.Millisecond
+)
+
+// multilineConfig decides which log lines of a container belong to the same
+// event, such as the lines of a stack trace.
+type multilineConfig struct {
+	startPattern    *regexp.Regexp
+	continuePattern *regexp.Regexp
+	maxLines        int
+	maxBytes        int
+	flushTimeout    time.Duration
+}
+
+// isContinuation returns true if line continues the event of the previous
+// line. A line matching the start pattern always starts a new event. If
+// there is a continuation pattern the line has to match it, otherwise any
+// line not matching the start pattern continues the event.
+func (c *multilineConfig) isContinuation(line string) bool {
+	if c.startPattern != nil && c.startPattern.MatchString(line) {
+		return false
+	}
+	if c.continuePattern != nil {
+		return c.continuePattern.MatchString(line)
+	}
+	return true
+}
+
+// getContainerSetting returns the value of the container environment
+// variable name, or else of the container label, or else dflt.
+func getContainerSetting(c *docker.Container, name, label, dflt string) string {
+	for _, e := range c.Config.Env {
+		if strings.HasPrefix(e, name+"=") {
+			return strings.TrimPrefix(e, name+"=")
+		}
+	}
+	if value, ok := c.Config.Labels[label]; ok {
+		return value
+	}
+	return dflt
+}
+
+// Get multiline aggregation settings configured with the settings
+// MULTILINE_START_PATTERN, MULTILINE_CONTINUE_PATTERN,
+// MULTILINE_MAX_LINES, MULTILINE_MAX_BYTES and MULTILINE_FLUSH_TIMEOUT, or
+// the labels logstash.multiline.start_pattern, logstash.multiline.continue_pattern,
+// logstash.multiline.max_lines, logstash.multiline.max_bytes and
+// logstash.multiline.flush_timeout. Returns nil if aggregation is disabled.
+func GetMultilineConfig(c *docker.Container, a *LogstashAdapter) *multilineConfig {
+	if config, ok := a.containers.get(c.ID, "multiline"); ok {
+		return config.(*multilineConfig)
+	}
+
+	config, err := newMultilineConfig(func(name string) string {
+		label := "logstash." + strings.ToLower(strings.Replace(name, "MULTILINE_", "multiline.", 1))
+		return getContainerSetting(c, name, label, a.config.multiline[name])
+	})
+	if err != nil {
+		log.Println("logstash: multiline disabled for container", c.Name+":", err)
+	}
+
+	a.containers.set(c.ID, "multiline", config)
+
+	return config
+}
+
+func newMultilineConfig(setting func(name string) string) (*multilineConfig, error) {
+	start := setting("MULTILINE_START_PATTERN")
+	cont := setting("MULTILINE_CONTINUE_PATTERN")
+	if start == "" && cont == "" {
+		return nil, nil
+	}
+
+	config := &multilineConfig{
+		maxLines:     defaultMultilineMaxLines,
+		maxBytes:     defaultMultilineMaxBytes,
+		flushTimeout: defaultMultilineFlushTimeout,
+	}
+
+	var err error
+	if start != "" {
+		if config.startPattern, err = regexp.Compile(start); err != nil {
+			return nil, err
+		}
+	}
+	if cont != "" {
+		if config.continuePattern, err = regexp.Compile(cont); err != nil {
+			return nil, err
+		}
+	}
+	if s := setting("MULTILINE_MAX_LINES"); s != "" {
+		if config.maxLines, err = strconv.Atoi(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := setting("MULTILINE_MAX_BYTES"); s != "" {
+		if config.maxBytes, err = strconv.Atoi(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := setting("MULTILINE_FLUSH_TIMEOUT"); s != "" {
+		if config.flushTimeout, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// multilineBuffer holds the lines of the event being aggregated.
+type multilineBuffer struct {
+	config   *multilineConfig
+	first    *router.Message
+	lines    []string
+	bytes    int
+	deadline time.Time
+}
+
+func (b *multilineBuffer) message() *router.Message {
+	m := *b.first
+	m.Data = strings.Join(b.lines, "\n")
+	return &m
+}
+
+// multilineAggregator joins consecutive lines of the same container stream
+// into a single message.
+type multilineAggregator struct {
+	buffers map[string]*multilineBuffer
+}
+
+func newMultilineAggregator() *multilineAggregator {
+	return &multilineAggregator{buffers: make(map[string]*multilineBuffer)}
+}
+
+// add adds m to the event being aggregated for its container and stream,
+// and returns the messages that are complete.
+func (g *multilineAggregator) add(m *router.Message, config *multilineConfig) []*router.Message {
+	if config == nil {
+		return []*router.Message{m}
+	}
+
+	key := m.Container.ID + "/" + m.Source
+	b := g.buffers[key]
+
+	if b != nil && b.config.isContinuation(m.Data) &&
+		len(b.lines) < b.config.maxLines && b.bytes+1+len(m.Data) <= b.config.maxBytes {
+		b.lines = append(b.lines, m.Data)
+		b.bytes += 1 + len(m.Data)
+		b.deadline = time.Now().Add(b.config.flushTimeout)
+		return nil
+	}
+
+	var complete []*router.Message
+	if b != nil {
+		complete = append(complete, b.message())
+	}
+
+	g.buffers[key] = &multilineBuffer{
+		config:   config,
+		first:    m,
+		lines:    []string{m.Data},
+		bytes:    len(m.Data),
+		deadline: time.Now().Add(config.flushTimeout),
+	}
+
+	return complete
+}
+
+// flushExpired returns the events that haven't received a line before their
+// flush timeout.
+func (g *multilineAggregator) flushExpired(now time.Time) []*router.Message {
+	var complete []*router.Message
+	for key, b := range g.buffers {
+		if now.After(b.deadline) {
+			complete = append(complete, b.message())
+			delete(g.buffers, key)
+		}
+	}
+	return complete
+}
+
+// flushAll returns every event being aggregated.
+func (g *multilineAggregator) flushAll() []*router.Message {
+	var complete []*router.Message
+	for key, b := range g.buffers {
+		complete = append(complete, b.message())
+		delete(g.buffers, key)
+	}
+	return complete
+}
@@ -0,0 +1,153 @@
+package logstash
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultilineIsContinuation(t *testing.T) {
+	assert := assert.New(t)
+
+	start := &multilineConfig{startPattern: regexp.MustCompile(`^\d{4}-`)}
+	assert.False(start.isContinuation("2017-01-01 ERROR boom"))
+	assert.True(start.isContinuation("\tat com.example.Main.main(Main.java:3)"))
+
+	cont := &multilineConfig{continuePattern: regexp.MustCompile(`^\s`)}
+	assert.False(cont.isContinuation("Traceback (most recent call last):"))
+	assert.True(cont.isContinuation(`  File "main.py", line 1, in <module>`))
+
+	both := &multilineConfig{startPattern: regexp.MustCompile(`^\S`), continuePattern: regexp.MustCompile(`^\s+at `)}
+	assert.False(both.isContinuation("Exception"))
+	assert.True(both.isContinuation("    at Main.java"))
+	assert.False(both.isContinuation("    something else"))
+}
+
+func TestMultilineAggregatorLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	container := docker.Container{ID: "ID", Config: &docker.Config{}}
+	config := &multilineConfig{
+		continuePattern: regexp.MustCompile(`^\s`),
+		maxLines:        2,
+		maxBytes:        100,
+		flushTimeout:    time.Hour,
+	}
+
+	g := newMultilineAggregator()
+	var events []string
+	for _, line := range []string{"a", " 1", " 2", "b", " 1", "c"} {
+		for _, m := range g.add(&router.Message{Container: &container, Source: "stdout", Data: line}, config) {
+			events = append(events, m.Data)
+		}
+	}
+	assert.Equal([]string{"a\n 1", " 2", "b\n 1"}, events)
+
+	assert.Empty(g.flushExpired(time.Now()))
+	flushed := g.flushExpired(time.Now().Add(2 * time.Hour))
+	assert.Len(flushed, 1)
+	assert.Equal("c", flushed[0].Data)
+	assert.Empty(g.flushAll())
+}
+
+func TestMultilineAggregatorSeparatesStreams(t *testing.T) {
+	assert := assert.New(t)
+
+	container := docker.Container{ID: "ID", Config: &docker.Config{}}
+	config := &multilineConfig{
+		continuePattern: regexp.MustCompile(`^\s`),
+		maxLines:        10,
+		maxBytes:        100,
+		flushTimeout:    time.Hour,
+	}
+
+	g := newMultilineAggregator()
+	assert.Empty(g.add(&router.Message{Container: &container, Source: "stdout", Data: "out"}, config))
+	assert.Empty(g.add(&router.Message{Container: &container, Source: "stderr", Data: "err"}, config))
+	assert.Empty(g.add(&router.Message{Container: &container, Source: "stderr", Data: " more err"}, config))
+	assert.Empty(g.add(&router.Message{Container: &container, Source: "stdout", Data: " more out"}, config))
+
+	events := map[string]string{}
+	for _, m := range g.flushAll() {
+		events[m.Source] = m.Data
+	}
+	assert.Equal(map[string]string{"stdout": "out\n more out", "stderr": "err\n more err"}, events)
+}
+
+func TestGetMultilineConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(MockConn{})
+
+	container := docker.Container{ID: "none", Config: &docker.Config{}}
+	assert.Nil(GetMultilineConfig(&container, adapter))
+
+	container = docker.Container{ID: "env", Config: &docker.Config{
+		Env: []string{"MULTILINE_START_PATTERN=^\\S", "MULTILINE_MAX_LINES=20"},
+	}}
+	config := GetMultilineConfig(&container, adapter)
+	assert.Equal(`^\S`, config.startPattern.String())
+	assert.Equal(20, config.maxLines)
+	assert.Equal(defaultMultilineFlushTimeout, config.flushTimeout)
+
+	container = docker.Container{ID: "label", Config: &docker.Config{
+		Labels: map[string]string{"logstash.multiline.continue_pattern": "^\\s", "logstash.multiline.flush_timeout": "5s"},
+	}}
+	config = GetMultilineConfig(&container, adapter)
+	assert.Equal(`^\s`, config.continuePattern.String())
+	assert.Equal(5*time.Second, config.flushTimeout)
+
+	container = docker.Container{ID: "invalid", Config: &docker.Config{
+		Env: []string{"MULTILINE_START_PATTERN=("},
+	}}
+	assert.Nil(GetMultilineConfig(&container, adapter))
+}
+
+func TestStreamMultiline(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	adapter := newTestAdapter(RecordingConn{writes: &writes})
+
+	logstream := make(chan *router.Message)
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+	containerConfig.Env = []string{"MULTILINE_CONTINUE_PATTERN=^\\s"}
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	go func() {
+		for _, line := range []string{
+			"Exception in thread \"main\" java.lang.NullPointerException",
+			"\tat com.example.Main.run(Main.java:10)",
+			"\tat com.example.Main.main(Main.java:3)",
+			"done",
+		} {
+			logstream <- &router.Message{Container: &container, Source: "stderr", Data: line, Time: time.Now()}
+		}
+		close(logstream)
+	}()
+
+	adapter.Stream(logstream)
+
+	var messages []interface{}
+	for _, w := range writes {
+		var data map[string]interface{}
+		assert.Nil(json.Unmarshal([]byte(w), &data))
+		messages = append(messages, data["message"])
+	}
+	assert.Equal([]interface{}{
+		"Exception in thread \"main\" java.lang.NullPointerException\n\tat com.example.Main.run(Main.java:10)\n\tat com.example.Main.main(Main.java:3)",
+		"done",
+	}, messages)
+}
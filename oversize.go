@@ -0,0 +1,183 @@
+package logstash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+const (
+	oversizeTruncate = "truncate"
+	oversizeSplit    = "split"
+	oversizeDrop     = "drop"
+)
+
+var errOversize = errors.New("message can't be made small enough")
+
+// fitEvent applies the oversize policy to js, the JSON encoding of an event
+// that is larger than the maximum UDP payload once delimited. It returns the
+// events to send instead, or nil if the event was dropped. Only the message
+// can be shortened, so an event that is too large without it is dropped.
+func (a *LogstashAdapter) fitEvent(js []byte) [][]byte {
+	var events [][]byte
+	err := errOversize
+
+	switch a.config.udpOversize {
+	case oversizeTruncate:
+		var event []byte
+		if event, err = truncateEvent(js, a.maxEventSize); err == nil {
+			events = [][]byte{event}
+		}
+	case oversizeSplit:
+		events, err = splitEvent(js, a.maxEventSize)
+	}
+
+	if err != nil {
+		atomic.AddUint64(&a.metrics.oversizeDropped, 1)
+		log.Printf("logstash: dropping event of %d bytes, larger than UDP_MAX_PAYLOAD: %v\n", len(js), err)
+		return nil
+	}
+	return events
+}
+
+// decodeEvent decodes an encoded event, keeping numbers as they were.
+func decodeEvent(js []byte) (map[string]interface{}, string, error) {
+	var data map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(js))
+	d.UseNumber()
+	if err := d.Decode(&data); err != nil {
+		return nil, "", err
+	}
+
+	message, ok := data["message"].(string)
+	if !ok {
+		return nil, "", errors.New("event has no message")
+	}
+	return data, message, nil
+}
+
+// truncateEvent shortens the message of the event js until its encoding and
+// delimiter fit in max bytes, and tags it as truncated.
+func truncateEvent(js []byte, max int) ([]byte, error) {
+	data, message, err := decodeEvent(js)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, _ := data["tags"].([]interface{})
+	data["tags"] = append(tags, "truncated")
+
+	for {
+		if js, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+		over := len(js) + 1 - max
+		if over <= 0 {
+			return js, nil
+		}
+		if message == "" {
+			return nil, errOversize
+		}
+
+		message = shorten(message, over)
+		data["message"] = message
+	}
+}
+
+// splitEvent splits the message of the event js into parts, each sent as an
+// event of at most max bytes with its delimiter. The parts share a random id
+// and are numbered from 1 in the split field.
+func splitEvent(js []byte, max int) ([][]byte, error) {
+	data, message, err := decodeEvent(js)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	// Find the parts with numbers at least as long as the real ones.
+	split := map[string]interface{}{
+		"id":    hex.EncodeToString(id),
+		"index": len(message),
+		"count": len(message),
+	}
+	data["split"] = split
+
+	// The size of the event without its message bounds the size of every
+	// part, so each part is only encoded about once.
+	data["message"] = ""
+	if js, err = json.Marshal(data); err != nil {
+		return nil, err
+	}
+	room := max - len(js) - 1
+
+	var parts []string
+	for rest := message; rest != ""; {
+		n := room
+		for {
+			part := truncateString(rest, n)
+			if part == "" {
+				return nil, errOversize
+			}
+			data["message"] = part
+			if js, err = json.Marshal(data); err != nil {
+				return nil, err
+			}
+			if over := len(js) + 1 - max; over > 0 {
+				n = len(shorten(part, over))
+				continue
+			}
+			parts = append(parts, part)
+			rest = rest[len(part):]
+			break
+		}
+	}
+
+	events := make([][]byte, len(parts))
+	for i, part := range parts {
+		data["message"] = part
+		split["index"] = i + 1
+		split["count"] = len(parts)
+		if events[i], err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// shorten returns s without about as many bytes at its end as its JSON
+// encoding has to lose over bytes, and at least one byte shorter.
+func shorten(s string, over int) string {
+	if s == "" {
+		return s
+	}
+
+	encoded, _ := json.Marshal(s)
+	quoted := len(encoded) - 2
+	cut := (over*len(s) + quoted - 1) / quoted
+	if cut < 1 {
+		cut = 1
+	}
+	return truncateString(s, len(s)-cut)
+}
+
+// truncateString returns at most the first n bytes of s, without cutting a
+// UTF-8 encoded character in two.
+func truncateString(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
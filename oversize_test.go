@@ -0,0 +1,169 @@
+package logstash
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func oversizeAdapter(policy string, max int) *LogstashAdapter {
+	adapter := newTestAdapter(MockConn{})
+	adapter.config.udpOversize = policy
+	adapter.config.logstashTags = "a"
+	adapter.maxEventSize = max
+	return adapter
+}
+
+func oversizeMessage(data string) *router.Message {
+	container := docker.Container{ID: "ID", Name: "name", Config: &docker.Config{}}
+	return &router.Message{Container: &container, Source: "stdout", Data: data}
+}
+
+func enqueued(adapter *LogstashAdapter, m *router.Message) []map[string]interface{} {
+	queue := newEventQueue(100, overflowBlock)
+	adapter.enqueue(queue, m)
+	queue.close()
+
+	var events []map[string]interface{}
+	for js := range queue.events {
+		var data map[string]interface{}
+		json.Unmarshal(js, &data)
+		events = append(events, data)
+		if len(js)+1 > adapter.maxEventSize {
+			events = append(events, nil)
+		}
+	}
+	return events
+}
+
+func TestOversizeTruncate(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := oversizeAdapter(oversizeTruncate, 1000)
+
+	events := enqueued(adapter, oversizeMessage(strings.Repeat("é\"", 800)))
+	assert.Len(events, 1)
+	assert.NotNil(events[0])
+	assert.Equal([]interface{}{"a", "truncated"}, events[0]["tags"])
+
+	message := events[0]["message"].(string)
+	assert.True(strings.HasPrefix(strings.Repeat("é\"", 800), message))
+	assert.True(len(message) > 300)
+
+	// Small events are left alone.
+	events = enqueued(adapter, oversizeMessage("small"))
+	assert.Len(events, 1)
+	assert.Equal("small", events[0]["message"])
+	assert.Equal([]interface{}{"a"}, events[0]["tags"])
+}
+
+func TestOversizeSplit(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := oversizeAdapter(oversizeSplit, 600)
+	data := strings.Repeat("0123456789", 200)
+
+	events := enqueued(adapter, oversizeMessage(data))
+	assert.True(len(events) > 3)
+
+	var message string
+	for i, event := range events {
+		assert.NotNil(event)
+		split := event["split"].(map[string]interface{})
+		assert.Equal(events[0]["split"].(map[string]interface{})["id"], split["id"])
+		assert.Equal(float64(i+1), split["index"])
+		assert.Equal(float64(len(events)), split["count"])
+		assert.Equal([]interface{}{"a"}, event["tags"])
+		message += event["message"].(string)
+	}
+	assert.Equal(data, message)
+}
+
+// largeMessage returns an event with a base64 dump of n bytes as its message.
+func largeMessage(n int) []byte {
+	data := make([]byte, n*3/4)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	js, _ := json.Marshal(map[string]interface{}{
+		"message": base64.StdEncoding.EncodeToString(data),
+		"tags":    []string{"a"},
+	})
+	return js
+}
+
+func TestSplitEventLargeMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	js := largeMessage(4 << 20)
+	events, err := splitEvent(js, 8192)
+	assert.Nil(err)
+
+	// Parts are filled up to the payload, so there are barely more than the
+	// size of the message divided by the payload.
+	assert.True(len(events) <= len(js)/8000+1, len(events))
+
+	var message string
+	for _, event := range events {
+		assert.True(len(event)+1 <= 8192)
+		data, part, err := decodeEvent(event)
+		assert.Nil(err)
+		assert.NotNil(data["split"])
+		message += part
+	}
+
+	data, original, _ := decodeEvent(js)
+	assert.NotNil(data)
+	assert.Equal(original, message)
+}
+
+func BenchmarkSplitEvent(b *testing.B) {
+	js := largeMessage(4 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		splitEvent(js, 8192)
+	}
+}
+
+func TestOversizeTruncateLogfmt(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := oversizeAdapter(oversizeTruncate, 1000)
+	adapter.config.decodeLogfmt = "true"
+	line := "level=info trace=" + strings.Repeat("x", 600)
+
+	events := enqueued(adapter, oversizeMessage(line))
+	assert.Len(events, 1)
+	assert.NotNil(events[0])
+	assert.Equal("info", events[0]["level"])
+	assert.True(strings.HasPrefix(line, events[0]["message"].(string)))
+	assert.Equal(uint64(0), adapter.metrics.oversizeDropped)
+}
+
+func TestOversizeDrop(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := oversizeAdapter(oversizeDrop, 600)
+	assert.Empty(enqueued(adapter, oversizeMessage(strings.Repeat("x", 2000))))
+	assert.Equal(uint64(1), adapter.metrics.oversizeDropped)
+
+	// A decoded event without a message can't be shortened.
+	adapter = oversizeAdapter(oversizeTruncate, 1000)
+	adapter.config.decodeJsonLogs = "true"
+	assert.Empty(enqueued(adapter, oversizeMessage(`{"blob": "`+strings.Repeat("x", 2000)+`"}`)))
+	assert.Equal(uint64(1), adapter.metrics.oversizeDropped)
+}
+
+func TestTruncateString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("ab", truncateString("abc", 2))
+	assert.Equal("abc", truncateString("abc", 5))
+	assert.Equal("", truncateString("abc", -1))
+	assert.Equal("a", truncateString("aé", 2))
+}
@@ -0,0 +1,50 @@
+package logstash
+
+import (
+	"net"
+)
+
+const (
+	// maxUDPPayload is the largest payload of an IPv4 UDP datagram.
+	maxUDPPayload        = 65507
+	defaultUDPMaxPayload = maxUDPPayload
+)
+
+// protocol frames encoded events and writes them to a connection.
+type protocol interface {
+	// writeBatch writes events to conn and returns once they are delivered,
+	// as far as the protocol is able to tell.
+	writeBatch(conn net.Conn, events [][]byte) error
+}
+
+// linesProtocol writes every event followed by a delimiter: a newline as
+// expected by the json_lines codec, or by the json codec when every event is
+// a datagram, or a null byte for GELF over TCP. A batch is written at once on
+// a stream connection. With a maximum payload, as for datagrams, it is split
+// into writes of as many whole events as fit, and an event larger than the
+// payload is written on its own.
+type linesProtocol struct {
+	delimiter  byte
+	maxPayload int
+}
+
+func (p linesProtocol) writeBatch(conn net.Conn, events [][]byte) error {
+	var buf []byte
+	for _, js := range events {
+		if p.maxPayload > 0 && len(buf) > 0 && len(buf)+len(js)+1 > p.maxPayload {
+			if _, err := conn.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+		buf = append(buf, js...)
+		buf = append(buf, p.delimiter)
+	}
+
+	if len(buf) > 0 {
+		if _, err := conn.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package logstash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinesProtocolSingleWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	err := linesProtocol{delimiter: '\n'}.writeBatch(RecordingConn{writes: &writes}, [][]byte{[]byte("1"), []byte("22"), []byte("333")})
+	assert.NoError(err)
+	assert.Equal([]string{"1\n22\n333\n"}, writes)
+}
+
+func TestLinesProtocolMaxPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	p := linesProtocol{delimiter: '\n', maxPayload: 6}
+	err := p.writeBatch(RecordingConn{writes: &writes}, [][]byte{
+		[]byte("1"), []byte("22"), []byte("333"), []byte("4444444"), []byte("55"),
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"1\n22\n", "333\n", "4444444\n", "55\n"}, writes)
+}
@@ -0,0 +1,176 @@
+package logstash
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	overflowBlock      = "block"
+	overflowDropNewest = "drop-newest"
+	overflowDropOldest = "drop-oldest"
+
+	defaultQueueSize   = 1024
+	dropReportInterval = 10 * time.Second
+
+	defaultBatchMaxEvents = 1
+	defaultBatchMaxBytes  = 1 << 20
+)
+
+// batchLimits bounds the events sent together in a single write. A maxBytes
+// of zero doesn't limit the size of a batch.
+type batchLimits struct {
+	maxEvents int
+	maxBytes  int
+	linger    time.Duration
+}
+
+// eventQueue is a bounded queue of encoded events between Stream and the
+// goroutine sending them, applying an overflow policy when it is full.
+type eventQueue struct {
+	events   chan []byte
+	overflow string
+	dropped  uint64
+	done     chan struct{}
+
+	// carry is an event received by batch that didn't fit the batch. Only
+	// the sending goroutine uses it.
+	carry []byte
+}
+
+func newEventQueue(size int, overflow string) *eventQueue {
+	q := &eventQueue{
+		events:   make(chan []byte, size),
+		overflow: overflow,
+		done:     make(chan struct{}),
+	}
+	go q.reportDrops()
+	return q
+}
+
+// push adds js to the queue. When the queue is full it waits for room, drops
+// js or drops the oldest queued event, depending on the overflow policy. It
+// returns the number of events dropped.
+func (q *eventQueue) push(js []byte) int {
+	switch q.overflow {
+	case overflowDropNewest:
+		select {
+		case q.events <- js:
+			return 0
+		default:
+			atomic.AddUint64(&q.dropped, 1)
+			return 1
+		}
+	case overflowDropOldest:
+		dropped := 0
+		for {
+			select {
+			case q.events <- js:
+				return dropped
+			default:
+			}
+			select {
+			case <-q.events:
+				atomic.AddUint64(&q.dropped, 1)
+				dropped++
+			default:
+			}
+		}
+	default:
+		q.events <- js
+		return 0
+	}
+}
+
+// next waits for the first event of the next batch. It returns false once
+// the queue is closed and empty.
+func (q *eventQueue) next() ([]byte, bool) {
+	if js := q.pending(); js != nil {
+		return js, true
+	}
+	js, ok := <-q.events
+	return js, ok
+}
+
+// pending returns the event that didn't fit the last batch, if any.
+func (q *eventQueue) pending() []byte {
+	js := q.carry
+	q.carry = nil
+	return js
+}
+
+// batch returns first together with the events that are already queued, up
+// to the limits. If the batch isn't full it waits up to the linger time for
+// more events. The event that would take the batch past its byte limit is
+// kept for the next batch, but first is always sent, however large.
+func (q *eventQueue) batch(first []byte, limits batchLimits) [][]byte {
+	events := [][]byte{first}
+	size := len(first) + 1
+
+	var linger <-chan time.Time
+	if limits.linger > 0 {
+		timer := time.NewTimer(limits.linger)
+		defer timer.Stop()
+		linger = timer.C
+	}
+
+	for len(events) < limits.maxEvents {
+		var js []byte
+		var ok bool
+
+		select {
+		case js, ok = <-q.events:
+		default:
+			if linger == nil {
+				return events
+			}
+			select {
+			case js, ok = <-q.events:
+			case <-linger:
+				return events
+			}
+		}
+		if !ok {
+			return events
+		}
+
+		if limits.maxBytes > 0 && size+len(js)+1 > limits.maxBytes {
+			q.carry = js
+			return events
+		}
+		events = append(events, js)
+		size += len(js) + 1
+	}
+	return events
+}
+
+// close stops accepting events. Queued events can still be received.
+func (q *eventQueue) close() {
+	close(q.events)
+	close(q.done)
+}
+
+// reportDrops periodically logs how many events were dropped since the last
+// report, until the queue is closed.
+func (q *eventQueue) reportDrops() {
+	ticker := time.NewTicker(dropReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.done:
+		}
+
+		if dropped := atomic.SwapUint64(&q.dropped, 0); dropped > 0 {
+			log.Printf("logstash: dropped %d events, queue full\n", dropped)
+		}
+
+		select {
+		case <-q.done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package logstash
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func drain(q *eventQueue) []string {
+	q.close()
+	var events []string
+	for js := range q.events {
+		events = append(events, string(js))
+	}
+	return events
+}
+
+func TestQueueDropNewest(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newEventQueue(2, overflowDropNewest)
+	q.push([]byte("1"))
+	q.push([]byte("2"))
+	q.push([]byte("3"))
+
+	assert.Equal(uint64(1), q.dropped)
+	assert.Equal([]string{"1", "2"}, drain(q))
+}
+
+func TestQueueDropOldest(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newEventQueue(2, overflowDropOldest)
+	q.push([]byte("1"))
+	q.push([]byte("2"))
+	q.push([]byte("3"))
+
+	assert.Equal(uint64(1), q.dropped)
+	assert.Equal([]string{"2", "3"}, drain(q))
+}
+
+func TestQueueBlock(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newEventQueue(1, overflowBlock)
+	q.push([]byte("1"))
+
+	pushed := make(chan bool)
+	go func() {
+		q.push([]byte("2"))
+		close(pushed)
+	}()
+
+	assert.Equal("1", string(<-q.events))
+	<-pushed
+	assert.Equal([]string{"2"}, drain(q))
+}
+
+func batchStrings(events [][]byte) []string {
+	var s []string
+	for _, js := range events {
+		s = append(s, string(js))
+	}
+	return s
+}
+
+func TestQueueBatchLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newEventQueue(10, overflowBlock)
+	for _, js := range []string{"1", "2", "3", "4", "55555", "6"} {
+		q.push([]byte(js))
+	}
+	q.close()
+	limits := batchLimits{maxEvents: 3, maxBytes: 7}
+
+	var batches [][]string
+	for js, ok := q.next(); ok; js, ok = q.next() {
+		batches = append(batches, batchStrings(q.batch(js, limits)))
+	}
+
+	// Each event counts with its delimiter, and the event that doesn't fit
+	// starts the next batch.
+	assert.Equal([][]string{{"1", "2", "3"}, {"4"}, {"55555"}, {"6"}}, batches)
+}
+
+func TestQueueBatchLinger(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newEventQueue(10, overflowBlock)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.push([]byte("2"))
+	}()
+
+	start := time.Now()
+	events := q.batch([]byte("1"), batchLimits{maxEvents: 3, linger: 200 * time.Millisecond})
+	assert.Equal([]string{"1", "2"}, batchStrings(events))
+	assert.True(time.Since(start) >= 200*time.Millisecond)
+
+	// Closing the queue ends the wait.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.close()
+	}()
+	start = time.Now()
+	events = q.batch([]byte("3"), batchLimits{maxEvents: 3, linger: time.Minute})
+	assert.Equal([]string{"3"}, batchStrings(events))
+	assert.True(time.Since(start) < time.Minute)
+}
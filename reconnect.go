@@ -0,0 +1,95 @@
+package logstash
+
+import (
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBackoffMin = 500 * time.Millisecond
+	defaultBackoffMax = 30 * time.Second
+)
+
+// backoff computes exponentially growing delays, with jitter, between
+// reconnection attempts.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+// next returns the delay before the next attempt, doubling the base delay for
+// every consecutive failure and picking a random delay between half of it
+// and all of it. The zero value uses the default delays.
+func (b *backoff) next() time.Duration {
+	if b.min <= 0 || b.max <= 0 {
+		b.min, b.max = defaultBackoffMin, defaultBackoffMax
+	}
+
+	d := b.min << b.attempt
+	if d <= 0 || d >= b.max {
+		d = b.max
+	} else {
+		b.attempt++
+	}
+
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// reset starts the delays over from the minimum.
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// tryWrite makes a single attempt at sending events. Once the DNS TTL has
+// passed the endpoints are looked up again, then events are written to each
+// healthy endpoint in turn, dialling it first if it has no connection, until
+// one of them succeeds. The write includes flushing the connection, for
+// compression. A failed write closes the connection of the endpoint and
+// schedules its next dial.
+func (a *LogstashAdapter) tryWrite(events [][]byte) bool {
+	now := time.Now()
+	a.refreshEndpoints(now)
+
+	for _, e := range a.candidates(now) {
+		if e.conn == nil {
+			if a.dial(e) != nil {
+				continue
+			}
+			log.Println("logstash: connected to", e.address)
+		}
+
+		start := time.Now()
+		err := a.protocol.writeBatch(e.conn, events)
+		if err == nil {
+			err = flushBatch(e.conn)
+		}
+		if err != nil {
+			log.Println("logstash: could not write to", e.address+":", err)
+			atomic.AddUint64(&a.metrics.writeErrors, 1)
+			e.fail(a.cooldown())
+			continue
+		}
+
+		e.succeed(len(events), time.Since(start))
+		a.metrics.sent(events, time.Since(start))
+		return true
+	}
+	return false
+}
+
+// write sends events to an endpoint. If every endpoint fails they are
+// re-dialled once their backoff delay has passed, until events could be sent
+// on a new connection or until the shutdown deadline has passed, in which case
+// it returns false.
+func (a *LogstashAdapter) write(events [][]byte) bool {
+	for !a.tryWrite(events) {
+		if !a.sleepUntil(a.redialAt()) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package logstash
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+type ErrConn struct {
+	MockConn
+}
+
+func (m ErrConn) Write(b []byte) (n int, err error) {
+	return 0, errors.New("broken pipe")
+}
+
+type MockTransport struct {
+	dials int
+	conns []net.Conn
+}
+
+func (t *MockTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	t.dials++
+	if len(t.conns) == 0 {
+		return nil, errors.New("connection refused")
+	}
+	conn := t.conns[0]
+	t.conns = t.conns[1:]
+	return conn, nil
+}
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	b := backoff{min: 10 * time.Millisecond, max: 80 * time.Millisecond}
+
+	for _, max := range []time.Duration{10, 20, 40, 80, 80} {
+		d := b.next()
+		assert.True(d >= max*time.Millisecond/2, d)
+		assert.True(d <= max*time.Millisecond, d)
+	}
+
+	b.reset()
+	assert.True(b.next() <= 10*time.Millisecond)
+}
+
+func TestStreamReconnectsAfterWriteError(t *testing.T) {
+	assert := assert.New(t)
+
+	transport := &MockTransport{conns: []net.Conn{ErrConn{}, MockConn{}}}
+
+	adapter := newTestAdapter(ErrConn{})
+	adapter.transport = transport
+	adapter.endpoints[0].backoff = backoff{min: time.Millisecond, max: time.Millisecond}
+
+	logstream := make(chan *router.Message)
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	message := router.Message{
+		Container: &container,
+		Source:    "FOOOOO",
+		Data:      "resent after reconnect",
+		Time:      time.Now(),
+	}
+
+	go func() {
+		logstream <- &message
+		close(logstream)
+	}()
+
+	adapter.Stream(logstream)
+
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(res), &data)
+	assert.Nil(err)
+
+	assert.Equal("resent after reconnect", data["message"])
+	assert.Equal(2, transport.dials)
+}
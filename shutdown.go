@@ -0,0 +1,144 @@
+package logstash
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdown holds the state of closing an adapter. The deadline is set before
+// closing is closed, and only read after. Stream holds a read lock of streams
+// while it runs.
+type shutdown struct {
+	once     sync.Once
+	closing  chan struct{}
+	deadline time.Time
+	streams  sync.RWMutex
+}
+
+func newShutdown() *shutdown {
+	return &shutdown{closing: make(chan struct{})}
+}
+
+func (s *shutdown) isClosing() bool {
+	select {
+	case <-s.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops Stream and sends the messages it already received, including
+// those the router handed over but Stream didn't take yet and lines being
+// aggregated, until the shutdown timeout has passed. Events that weren't sent
+// by then are kept in the spool if there is one, and else lost. The
+// connection is closed once Stream has returned. Close returns an error if
+// events were lost.
+func (a *LogstashAdapter) Close() error {
+	a.shutdown.once.Do(func() {
+		a.shutdown.deadline = time.Now().Add(a.config.shutdownTimeout)
+		close(a.shutdown.closing)
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		a.shutdown.streams.Lock()
+		a.shutdown.streams.Unlock()
+		close(stopped)
+	}()
+
+	// Give a write that was under way when the deadline passed some time
+	// to fail.
+	select {
+	case <-stopped:
+	case <-time.After(time.Until(a.shutdown.deadline) + time.Second):
+		return fmt.Errorf("logstash: %s not stopped within the shutdown timeout", a.route.Address)
+	}
+
+	a.closeConns()
+
+	if lost := atomic.LoadUint64(&a.metrics.shutdownDropped); lost > 0 {
+		return fmt.Errorf("logstash: %d events not sent to %s within the shutdown timeout", lost, a.route.Address)
+	}
+	return nil
+}
+
+// sleepUntil waits until t, or until the shutdown deadline if the adapter is
+// closed before. It returns false once the deadline has passed.
+func (a *LogstashAdapter) sleepUntil(t time.Time) bool {
+	for {
+		closing := a.shutdown.closing
+		if a.shutdown.isClosing() {
+			if !time.Now().Before(a.shutdown.deadline) {
+				return false
+			}
+			if a.shutdown.deadline.Before(t) {
+				t = a.shutdown.deadline
+			}
+			closing = nil
+		}
+
+		timer := time.NewTimer(time.Until(t))
+		select {
+		case <-timer.C:
+			return true
+		case <-closing:
+			timer.Stop()
+		}
+	}
+}
+
+var shutdownHook struct {
+	sync.Mutex
+	adapters []*LogstashAdapter
+	signals  chan os.Signal
+}
+
+// closeOnSignal closes a when logspout receives SIGTERM or SIGINT. Once every
+// adapter is closed the signal is raised again, to stop logspout like it
+// would have without the hook.
+func closeOnSignal(a *LogstashAdapter) {
+	shutdownHook.Lock()
+	defer shutdownHook.Unlock()
+
+	shutdownHook.adapters = append(shutdownHook.adapters, a)
+	if shutdownHook.signals != nil {
+		return
+	}
+
+	shutdownHook.signals = make(chan os.Signal, 1)
+	signal.Notify(shutdownHook.signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-shutdownHook.signals
+
+		shutdownHook.Lock()
+		adapters := shutdownHook.adapters
+		shutdownHook.Unlock()
+
+		var wg sync.WaitGroup
+		for _, a := range adapters {
+			wg.Add(1)
+			go func(a *LogstashAdapter) {
+				defer wg.Done()
+				if err := a.Close(); err != nil {
+					log.Println(err)
+				}
+			}(a)
+		}
+		wg.Wait()
+
+		signal.Stop(shutdownHook.signals)
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			p.Signal(sig)
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+package logstash
+
+import (
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+type ClosingConn struct {
+	RecordingConn
+	closed *int32
+}
+
+func (m ClosingConn) Close() error {
+	atomic.AddInt32(m.closed, 1)
+	return nil
+}
+
+func TestCloseFlushesPendingEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	var closed int32
+	adapter := newTestAdapter(ClosingConn{RecordingConn{writes: &writes}, &closed})
+
+	container := docker.Container{ID: "ID", Name: "name", Config: &docker.Config{
+		Env: []string{"MULTILINE_START_PATTERN=^start"},
+	}}
+	message := func(data string) *router.Message {
+		return &router.Message{Container: &container, Source: "stdout", Data: data, Time: time.Now()}
+	}
+
+	logstream := make(chan *router.Message, 10)
+	streamed := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(streamed)
+	}()
+
+	// Wait for Stream to take the first message, then leave the rest in the
+	// channel and the last event in the multiline aggregator.
+	logstream <- message("start 1")
+	for len(logstream) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	logstream <- message("  continued 1")
+	logstream <- message("start 2")
+	logstream <- message("  continued 2")
+
+	assert.NoError(adapter.Close())
+
+	select {
+	case <-streamed:
+	case <-time.After(time.Second):
+		t.Fatal("Stream didn't return after Close")
+	}
+
+	var messages []string
+	for _, js := range writes {
+		var data map[string]interface{}
+		assert.NoError(json.Unmarshal([]byte(js), &data))
+		messages = append(messages, data["message"].(string))
+	}
+	assert.Equal([]string{"start 1\n  continued 1", "start 2\n  continued 2"}, messages)
+	assert.Equal(int32(1), atomic.LoadInt32(&closed))
+	assert.Nil(adapter.endpoints[0].conn)
+}
+
+func TestCloseGivesUpAtDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	adapter := newTestAdapter(ErrConn{})
+	adapter.transport = &MockTransport{}
+	adapter.endpoints[0].backoff = backoff{min: time.Hour, max: time.Hour}
+	adapter.config.shutdownTimeout = 100 * time.Millisecond
+
+	container := docker.Container{ID: "ID", Name: "name", Config: &docker.Config{}}
+
+	logstream := make(chan *router.Message)
+	go adapter.Stream(logstream)
+	logstream <- &router.Message{Container: &container, Source: "stdout", Data: "lost", Time: time.Now()}
+
+	start := time.Now()
+	err := adapter.Close()
+	assert.Error(err)
+	assert.Contains(err.Error(), "1 events not sent")
+	assert.True(time.Since(start) < time.Second)
+	assert.Equal(uint64(1), atomic.LoadUint64(&adapter.metrics.shutdownDropped))
+}
+
+func TestStreamClosesConnection(t *testing.T) {
+	assert := assert.New(t)
+
+	var writes []string
+	var closed int32
+	var conn net.Conn = ClosingConn{RecordingConn{writes: &writes}, &closed}
+	adapter := newTestAdapter(conn)
+
+	logstream := make(chan *router.Message)
+	close(logstream)
+	adapter.Stream(logstream)
+
+	assert.Equal(int32(1), atomic.LoadInt32(&closed))
+	assert.NoError(adapter.Close())
+}
@@ -0,0 +1,437 @@
+package logstash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultSpoolMaxBytes = 1 << 30
+	defaultSpoolMaxAge   = 24 * time.Hour
+	maxSegmentSize       = 16 << 20
+
+	spoolReplayInterval = time.Second
+	spoolHeaderSize     = 12
+	spoolOffsetFile     = "offset"
+)
+
+var spoolNameReplacer = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// spool persists encoded events in segment files while Logstash can't be
+// reached. Each record holds the time it was spooled, its length and the
+// event. Records are read back in order from a read offset that is saved in
+// the spool directory, so replaying continues where it left off after a
+// restart.
+type spool struct {
+	dir         string
+	maxBytes    int64
+	maxAge      time.Duration
+	segmentSize int64
+
+	// segments on disk, oldest first. The oldest is the one being read, and
+	// the newest the one being written once writer is open.
+	segments []uint64
+	sizes    map[uint64]int64
+	size     int64
+
+	writer *os.File
+
+	reader    *os.File
+	readerSeg uint64
+	readOff   int64
+	nextOff   int64
+}
+
+// newSpool opens the spool of route in its own directory below the spool
+// directory of config. It returns nil if spooling is disabled.
+func newSpool(route *router.Route, config *routeConfig) (*spool, error) {
+	if config.spoolDir == "" {
+		return nil, nil
+	}
+
+	dir := filepath.Join(config.spoolDir, spoolName(route))
+	if err := adoptLegacySpool(filepath.Join(config.spoolDir, legacySpoolName(route)), dir); err != nil {
+		return nil, err
+	}
+	return openSpool(dir, config.spoolMaxBytes, config.spoolMaxAge)
+}
+
+// spoolName returns the name of the spool directory of route. Every route
+// gets its own directory, named after something that doesn't change when
+// logspout restarts: its adapter and address, and a hash of its options, since
+// routes to the same address may differ only in their options.
+func spoolName(route *router.Route) string {
+	options := make([]string, 0, len(route.Options))
+	for k, v := range route.Options {
+		options = append(options, url.QueryEscape(k)+"="+url.QueryEscape(v))
+	}
+	sort.Strings(options)
+	hash := sha256.Sum256([]byte(strings.Join(options, "&")))
+
+	return legacySpoolName(route) + "_" + hex.EncodeToString(hash[:6])
+}
+
+// legacySpoolName returns the name of the spool directory of route before
+// options were hashed into it.
+func legacySpoolName(route *router.Route) string {
+	return spoolNameReplacer.ReplaceAllString(route.Adapter+"_"+route.Address, "_")
+}
+
+// adoptLegacySpool renames the spool directory legacy, named without the hash
+// of the options, to dir, so that events spooled before the upgrade are still
+// replayed. Routes to the same address shared the legacy directory, so the
+// first of them to start adopts it.
+func adoptLegacySpool(legacy, dir string) error {
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return nil
+	}
+	if info, err := os.Stat(legacy); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	if err := os.Rename(legacy, dir); err != nil {
+		return fmt.Errorf("logstash: could not rename spool directory %s to %s: %v", legacy, dir, err)
+	}
+	log.Println("logstash: renamed spool directory", legacy, "to", dir)
+	return nil
+}
+
+func openSpool(dir string, maxBytes int64, maxAge time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &spool{
+		dir:         dir,
+		maxBytes:    maxBytes,
+		maxAge:      maxAge,
+		segmentSize: maxBytes / 8,
+		sizes:       make(map[uint64]int64),
+	}
+	if s.segmentSize > maxSegmentSize {
+		s.segmentSize = maxSegmentSize
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		seg, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, seg)
+		s.sizes[seg] = info.Size()
+		s.size += info.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, spoolOffsetFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var seg uint64
+	var off int64
+	if _, err := fmt.Sscan(string(content), &seg, &off); err == nil {
+		// Segments before the saved one were replayed but not yet removed.
+		for len(s.segments) > 0 && s.segments[0] < seg {
+			s.remove()
+		}
+		if len(s.segments) > 0 && s.segments[0] == seg {
+			s.readOff = off
+		}
+	}
+
+	return s, nil
+}
+
+func (s *spool) path(seg uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d.seg", seg))
+}
+
+// empty returns true if every spooled record has been replayed.
+func (s *spool) empty() bool {
+	if len(s.segments) == 0 {
+		return true
+	}
+	return len(s.segments) == 1 && s.readOff >= s.sizes[s.segments[0]]
+}
+
+// append adds js to the end of the spool, making room for it by removing
+// the oldest segments if the spool has grown past its size cap.
+func (s *spool) append(js []byte) error {
+	record := int64(spoolHeaderSize + len(js))
+
+	if s.writer == nil || s.sizes[s.segments[len(s.segments)-1]] >= s.segmentSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+
+	for s.size+record > s.maxBytes && len(s.segments) > 1 {
+		log.Println("logstash: spool full, discarding", s.path(s.segments[0]))
+		s.remove()
+	}
+	if s.size+record > s.maxBytes {
+		return errors.New("logstash: event larger than the spool")
+	}
+
+	buf := make([]byte, record)
+	binary.BigEndian.PutUint64(buf, uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(js)))
+	copy(buf[spoolHeaderSize:], js)
+
+	n, err := s.writer.Write(buf)
+	seg := s.segments[len(s.segments)-1]
+	s.sizes[seg] += int64(n)
+	s.size += int64(n)
+
+	return err
+}
+
+// roll closes the segment being written and starts a new one. Segments that
+// are entirely older than the age cap are removed on the way.
+func (s *spool) roll() error {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+
+	for len(s.segments) > 0 && s.maxAge > 0 {
+		info, err := os.Stat(s.path(s.segments[0]))
+		if err != nil || time.Since(info.ModTime()) <= s.maxAge {
+			break
+		}
+		log.Println("logstash: spool segment too old, discarding", s.path(s.segments[0]))
+		s.remove()
+	}
+
+	var seg uint64
+	if len(s.segments) > 0 {
+		seg = s.segments[len(s.segments)-1] + 1
+	}
+
+	f, err := os.OpenFile(s.path(seg), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.writer = f
+	s.segments = append(s.segments, seg)
+	s.sizes[seg] = 0
+
+	return nil
+}
+
+// remove deletes the oldest segment, which is the one being read.
+func (s *spool) remove() {
+	seg := s.segments[0]
+
+	if s.reader != nil && s.readerSeg == seg {
+		s.reader.Close()
+		s.reader = nil
+	}
+	if len(s.segments) == 1 && s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+
+	os.Remove(s.path(seg))
+	s.segments = s.segments[1:]
+	s.size -= s.sizes[seg]
+	delete(s.sizes, seg)
+	s.readOff = 0
+	s.nextOff = 0
+	s.saveOffset()
+}
+
+// peek returns up to n records to replay, from a single segment, without
+// consuming them. Records older than the age cap are skipped. It returns nil
+// if the spool is empty.
+func (s *spool) peek(n int) ([][]byte, error) {
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+		writing := len(s.segments) == 1 && s.writer != nil
+
+		if s.reader == nil || s.readerSeg != seg {
+			if s.reader != nil {
+				s.reader.Close()
+			}
+			f, err := os.Open(s.path(seg))
+			if err != nil {
+				return nil, err
+			}
+			s.reader = f
+			s.readerSeg = seg
+		}
+
+		var events [][]byte
+		off := s.readOff
+		for len(events) < n {
+			record, err := s.read(off)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			off += int64(len(record))
+
+			spooled := time.Unix(0, int64(binary.BigEndian.Uint64(record)))
+			if s.maxAge > 0 && time.Since(spooled) > s.maxAge {
+				continue
+			}
+			events = append(events, record[spoolHeaderSize:])
+		}
+
+		s.nextOff = off
+		if len(events) > 0 {
+			return events, nil
+		}
+		if off > s.readOff {
+			s.commit()
+		}
+		if writing {
+			return nil, nil
+		}
+
+		// The segment is fully replayed, or ends with a record that was
+		// cut short when logspout stopped.
+		s.remove()
+	}
+
+	return nil, nil
+}
+
+// read reads the record at off, including its header.
+func (s *spool) read(off int64) ([]byte, error) {
+	header := make([]byte, spoolHeaderSize)
+	if _, err := s.reader.ReadAt(header, off); err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, spoolHeaderSize+int(binary.BigEndian.Uint32(header[8:])))
+	if _, err := s.reader.ReadAt(record, off); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// commit consumes the records returned by the last peek.
+func (s *spool) commit() {
+	s.readOff = s.nextOff
+	s.saveOffset()
+}
+
+func (s *spool) saveOffset() {
+	var seg uint64
+	if len(s.segments) > 0 {
+		seg = s.segments[0]
+	}
+
+	tmp := filepath.Join(s.dir, spoolOffsetFile+".tmp")
+	content := fmt.Sprintf("%d %d\n", seg, s.readOff)
+	if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+		log.Println("logstash: could not save spool offset:", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, spoolOffsetFile)); err != nil {
+		log.Println("logstash: could not save spool offset:", err)
+	}
+}
+
+// close closes the open segment files. Spooled records stay on disk.
+func (s *spool) close() {
+	if s.writer != nil {
+		s.writer.Close()
+		s.writer = nil
+	}
+	if s.reader != nil {
+		s.reader.Close()
+		s.reader = nil
+	}
+}
+
+// replaySpool sends spooled events in order until the spool is empty or a
+// write fails, and returns true if the spool was emptied.
+func (a *LogstashAdapter) replaySpool() bool {
+	for {
+		events, err := a.spool.peek(a.batch.maxEvents)
+		if err != nil {
+			log.Println("logstash: could not read spool:", err)
+			return false
+		}
+		if events == nil {
+			return true
+		}
+		if !a.tryWrite(events) {
+			return false
+		}
+		a.spool.commit()
+	}
+}
+
+// sendSpooled sends events from queue, spooling them to disk instead of
+// waiting whenever Logstash can't be reached, and replays the spool once it
+// can be reached again.
+func (a *LogstashAdapter) sendSpooled(queue *eventQueue) {
+	defer a.spool.close()
+
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		js := queue.pending()
+		if js == nil {
+			var ok bool
+			select {
+			case js, ok = <-queue.events:
+				if !ok {
+					a.replaySpool()
+					return
+				}
+			case <-ticker.C:
+				if !a.spool.empty() {
+					a.replaySpool()
+				}
+				continue
+			}
+		}
+
+		events := queue.batch(js, a.batch)
+		if a.spool.empty() && a.tryWrite(events) {
+			continue
+		}
+		for _, js := range events {
+			if err := a.spool.append(js); err != nil {
+				log.Println("logstash: could not spool event:", err)
+			}
+		}
+		a.replaySpool()
+	}
+}
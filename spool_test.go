@@ -0,0 +1,233 @@
+package logstash
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+type RecordingConn struct {
+	MockConn
+	writes *[]string
+}
+
+func (m RecordingConn) Write(b []byte) (n int, err error) {
+	*m.writes = append(*m.writes, string(b))
+	return len(b), nil
+}
+
+func replay(s *spool) []string {
+	var events []string
+	for {
+		js, err := s.peek(2)
+		if err != nil || js == nil {
+			return events
+		}
+		for _, e := range js {
+			events = append(events, string(e))
+		}
+		s.commit()
+	}
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+
+	s, err := openSpool(dir, 1<<20, time.Hour)
+	assert.Nil(err)
+	assert.True(s.empty())
+
+	for _, e := range []string{"1", "2", "3"} {
+		assert.Nil(s.append([]byte(e)))
+	}
+	assert.False(s.empty())
+
+	assert.Equal([]string{"1", "2", "3"}, replay(s))
+	assert.True(s.empty())
+}
+
+func TestSpoolResumesFromSavedOffset(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+
+	s, err := openSpool(dir, 1<<20, time.Hour)
+	assert.Nil(err)
+	for _, e := range []string{"1", "2", "3"} {
+		assert.Nil(s.append([]byte(e)))
+	}
+
+	js, err := s.peek(1)
+	assert.Nil(err)
+	assert.Equal([][]byte{[]byte("1")}, js)
+	s.commit()
+	s.close()
+
+	s, err = openSpool(dir, 1<<20, time.Hour)
+	assert.Nil(err)
+	assert.False(s.empty())
+	assert.Nil(s.append([]byte("4")))
+
+	assert.Equal([]string{"2", "3", "4"}, replay(s))
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Len(paths, 1)
+}
+
+func TestSpoolDiscardsOldestWhenFull(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+
+	// Room for four records of one byte, in segments of two records.
+	s, err := openSpool(dir, 4*(spoolHeaderSize+1), time.Hour)
+	assert.Nil(err)
+	s.segmentSize = 2 * (spoolHeaderSize + 1)
+
+	for _, e := range []string{"1", "2", "3", "4", "5"} {
+		assert.Nil(s.append([]byte(e)))
+	}
+
+	assert.Equal([]string{"3", "4", "5"}, replay(s))
+	assert.NotNil(s.append(make([]byte, 4*spoolHeaderSize)))
+}
+
+func TestSpoolSkipsExpiredRecords(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+
+	s, err := openSpool(dir, 1<<20, 20*time.Millisecond)
+	assert.Nil(err)
+
+	assert.Nil(s.append([]byte("old")))
+	time.Sleep(40 * time.Millisecond)
+	assert.Nil(s.append([]byte("new")))
+
+	assert.Equal([]string{"new"}, replay(s))
+}
+
+func TestSpoolName(t *testing.T) {
+	assert := assert.New(t)
+
+	route := func(options map[string]string) *router.Route {
+		return &router.Route{Adapter: "logstash+tcp", Address: "logstash:5000", Options: options}
+	}
+
+	plain := spoolName(route(nil))
+	assert.True(strings.HasPrefix(plain, "logstash_tcp_logstash_5000_"))
+	assert.Equal(plain, spoolName(route(map[string]string{})))
+
+	web := spoolName(route(map[string]string{"include_containers": "web", "encoder": "gelf"}))
+	assert.True(strings.HasPrefix(web, "logstash_tcp_logstash_5000_"))
+	assert.Equal(web, spoolName(route(map[string]string{"encoder": "gelf", "include_containers": "web"})))
+	assert.NotEqual(web, spoolName(route(map[string]string{"include_containers": "db", "encoder": "gelf"})))
+	assert.NotEqual(web, spoolName(route(map[string]string{"include_containers": "web"})))
+}
+
+func TestNewSpoolAdoptsLegacyDirectory(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+
+	route := &router.Route{Adapter: "logstash+tcp", Address: "logstash:5000", Options: map[string]string{"encoder": "gelf"}}
+	config := &routeConfig{spoolDir: dir, spoolMaxBytes: 1 << 20, spoolMaxAge: time.Hour}
+
+	legacy, err := openSpool(filepath.Join(dir, "logstash_tcp_logstash_5000"), 1<<20, time.Hour)
+	assert.Nil(err)
+	assert.Nil(legacy.append([]byte("1")))
+	legacy.close()
+
+	s, err := newSpool(route, config)
+	assert.Nil(err)
+	assert.Equal([]string{"1"}, replay(s))
+	s.close()
+
+	_, err = os.Stat(filepath.Join(dir, "logstash_tcp_logstash_5000"))
+	assert.True(os.IsNotExist(err))
+
+	// Once adopted, another route to the same address starts a spool of its own.
+	s, err = newSpool(&router.Route{Adapter: "logstash+tcp", Address: "logstash:5000"}, config)
+	assert.Nil(err)
+	assert.True(s.empty())
+	s.close()
+}
+
+func TestStreamSpoolsWhileDisconnected(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "spool")
+	defer os.RemoveAll(dir)
+
+	s, err := openSpool(dir, 1<<20, time.Hour)
+	assert.Nil(err)
+
+	var writes []string
+	transport := &MockTransport{}
+
+	adapter := newTestAdapter(nil)
+	adapter.transport = transport
+	adapter.endpoints[0].backoff = backoff{min: time.Hour, max: time.Hour}
+	adapter.spool = s
+
+	logstream := make(chan *router.Message)
+
+	containerConfig := docker.Config{}
+	containerConfig.Image = "image"
+	containerConfig.Hostname = "hostname"
+
+	container := docker.Container{}
+	container.Name = "name"
+	container.ID = "ID"
+	container.Config = &containerConfig
+
+	go func() {
+		for _, line := range []string{"first", "second"} {
+			logstream <- &router.Message{Container: &container, Source: "stdout", Data: line, Time: time.Now()}
+		}
+		close(logstream)
+	}()
+
+	adapter.Stream(logstream)
+
+	assert.Equal(1, transport.dials)
+	assert.Empty(writes)
+
+	// Logstash is back: the spool is replayed before anything else is sent.
+	s, err = openSpool(dir, 1<<20, time.Hour)
+	assert.Nil(err)
+	adapter.spool = s
+	adapter.endpoints[0].conn = RecordingConn{writes: &writes}
+
+	logstream = make(chan *router.Message)
+	go func() {
+		logstream <- &router.Message{Container: &container, Source: "stdout", Data: "third", Time: time.Now()}
+		close(logstream)
+	}()
+
+	adapter.Stream(logstream)
+
+	var messages []interface{}
+	for _, w := range writes {
+		var data map[string]interface{}
+		assert.Nil(json.Unmarshal([]byte(w), &data))
+		messages = append(messages, data["message"])
+	}
+	assert.Equal([]interface{}{"first", "second", "third"}, messages)
+	assert.True(s.empty())
+}
@@ -0,0 +1,193 @@
+package logstash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTLSMinVersion = "1.2"
+	tlsDialTimeout       = 30 * time.Second
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parsePin parses an SPKI pin: the base64 encoded SHA-256 hash of the public
+// key of a certificate, optionally prefixed with sha256/ as in HPKP.
+func parsePin(s string) ([]byte, error) {
+	pin, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(s), "sha256/"))
+	if err != nil {
+		return nil, err
+	}
+	if len(pin) != sha256.Size {
+		return nil, fmt.Errorf("pin is %d bytes, not %d", len(pin), sha256.Size)
+	}
+	return pin, nil
+}
+
+// tlsTransport dials TLS connections with the TLS settings of a route. The
+// certificate files are read again when they change, so a rotated certificate
+// is used from the next connection on without restarting logspout.
+type tlsTransport struct {
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+	minVersion uint16
+	pins       [][]byte
+
+	mu       sync.Mutex
+	config   *tls.Config
+	modTimes map[string]time.Time
+}
+
+// newTLSTransport returns the transport for the TLS settings of config. It
+// fails if the certificate files can't be loaded.
+func newTLSTransport(config *routeConfig) (*tlsTransport, error) {
+	minVersion := config.tlsMinVersion
+	if minVersion == "" {
+		minVersion = defaultTLSMinVersion
+	}
+
+	t := &tlsTransport{
+		caFile:     config.tlsCAFile,
+		certFile:   config.tlsCertFile,
+		keyFile:    config.tlsKeyFile,
+		serverName: config.tlsServerName,
+		minVersion: tlsVersions[minVersion],
+		pins:       config.tlsPins,
+	}
+	if _, err := t.tlsConfig(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Dial connects to addr. The route options are not used, the TLS settings
+// were already read from them when the transport was created.
+func (t *tlsTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	config, err := t.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: tlsDialTimeout}, "tcp", addr, config)
+}
+
+// tlsConfig returns the TLS configuration, loading the certificate files
+// again if they have changed since they were last loaded. If they can't be
+// loaded, for instance while they are being replaced, the previous
+// configuration is kept.
+func (t *tlsTransport) tlsConfig() (*tls.Config, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	modTimes := t.files()
+	if t.config != nil && equalModTimes(modTimes, t.modTimes) {
+		return t.config, nil
+	}
+
+	config, err := t.load()
+	if err != nil {
+		if t.config == nil {
+			return nil, err
+		}
+		log.Println("logstash: could not reload TLS certificates:", err)
+		return t.config, nil
+	}
+
+	t.config = config
+	t.modTimes = modTimes
+	return config, nil
+}
+
+// files returns the modification times of the certificate files.
+func (t *tlsTransport) files() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{t.caFile, t.certFile, t.keyFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func equalModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, t := range a {
+		if !t.Equal(b[path]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *tlsTransport) load() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: t.serverName,
+		MinVersion: t.minVersion,
+	}
+
+	if t.caFile != "" {
+		pem, err := ioutil.ReadFile(t.caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("logstash: no certificates in " + t.caFile)
+		}
+	}
+
+	if t.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(t.pins) > 0 {
+		config.VerifyPeerCertificate = t.verifyPins
+	}
+
+	return config, nil
+}
+
+// verifyPins checks that the public key of a certificate in the chain of the
+// server, up to and including the CA, matches one of the pins. It runs after
+// the usual verification of the chain.
+func (t *tlsTransport) verifyPins(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range t.pins {
+				if bytes.Equal(hash[:], pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("logstash: no certificate of the server matches TLS_PINS")
+}
@@ -0,0 +1,328 @@
+package logstash
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a certificate and its key, signed by parent or else self-signed.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func (c *testCert) pin() string {
+	hash := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// writeFiles writes the certificate and key of c to dir, as name.pem and
+// name.key.
+func (c *testCert) writeFiles(t *testing.T, dir, name string) {
+	key, _ := x509.MarshalECPrivateKey(c.key)
+	for path, block := range map[string]*pem.Block{
+		name + ".pem": {Type: "CERTIFICATE", Bytes: c.der},
+		name + ".key": {Type: "EC PRIVATE KEY", Bytes: key},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, path), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serveTLS accepts TLS connections with config, completing the handshake of
+// each, and returns the address it listens on.
+func serveTLS(t *testing.T, config *tls.Config) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				ioutil.ReadAll(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String()
+}
+
+func dialTLS(t *testing.T, config *routeConfig, addr string) error {
+	transport, err := newTLSTransport(config)
+	if err != nil {
+		return err
+	}
+	conn, err := transport.Dial(addr, nil)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func TestTLSTransportServerName(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	ca.writeFiles(t, dir, "ca")
+	server := newTestCert(t, "logstash.test", ca)
+	addr := serveTLS(t, &tls.Config{Certificates: []tls.Certificate{server.tlsCertificate()}})
+
+	config := &routeConfig{tlsCAFile: filepath.Join(dir, "ca.pem"), tlsMinVersion: "1.2"}
+	assert.NotNil(dialTLS(t, config, addr))
+
+	config.tlsServerName = "logstash.test"
+	assert.Nil(dialTLS(t, config, addr))
+
+	// Without the CA the server isn't trusted.
+	config.tlsCAFile = ""
+	assert.NotNil(dialTLS(t, config, addr))
+}
+
+func TestTLSTransportClientCertificate(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	ca.writeFiles(t, dir, "ca")
+	newTestCert(t, "client", ca).writeFiles(t, dir, "client")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	addr := serveTLS(t, &tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t, "logstash.test", ca).tlsCertificate()},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MaxVersion:   tls.VersionTLS12,
+	})
+
+	config := &routeConfig{
+		tlsCAFile:     filepath.Join(dir, "ca.pem"),
+		tlsServerName: "logstash.test",
+		tlsMinVersion: "1.2",
+	}
+	assert.NotNil(dialTLS(t, config, addr))
+
+	config.tlsCertFile = filepath.Join(dir, "client.pem")
+	config.tlsKeyFile = filepath.Join(dir, "client.key")
+	assert.Nil(dialTLS(t, config, addr))
+}
+
+func TestTLSTransportMinVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	ca.writeFiles(t, dir, "ca")
+	addr := serveTLS(t, &tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t, "logstash.test", ca).tlsCertificate()},
+		MaxVersion:   tls.VersionTLS12,
+	})
+
+	config := &routeConfig{tlsCAFile: filepath.Join(dir, "ca.pem"), tlsServerName: "logstash.test", tlsMinVersion: "1.2"}
+	assert.Nil(dialTLS(t, config, addr))
+
+	config.tlsMinVersion = "1.3"
+	assert.NotNil(dialTLS(t, config, addr))
+}
+
+func TestTLSTransportPins(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	ca.writeFiles(t, dir, "ca")
+	server := newTestCert(t, "logstash.test", ca)
+	addr := serveTLS(t, &tls.Config{Certificates: []tls.Certificate{server.tlsCertificate()}})
+
+	pin := func(s string) [][]byte {
+		p, err := parsePin(s)
+		assert.Nil(err)
+		return [][]byte{p}
+	}
+	config := &routeConfig{tlsCAFile: filepath.Join(dir, "ca.pem"), tlsServerName: "logstash.test", tlsMinVersion: "1.2"}
+
+	config.tlsPins = pin(server.pin())
+	assert.Nil(dialTLS(t, config, addr))
+
+	// The CA can be pinned instead of the server certificate.
+	config.tlsPins = pin(ca.pin())
+	assert.Nil(dialTLS(t, config, addr))
+
+	config.tlsPins = pin(newTestCert(t, "other", nil).pin())
+	assert.NotNil(dialTLS(t, config, addr))
+}
+
+func TestTLSTransportReloadsCertificates(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+
+	oldCA := newTestCert(t, "ca", nil)
+	oldCA.writeFiles(t, dir, "ca")
+	newCA := newTestCert(t, "ca", nil)
+	addr := serveTLS(t, &tls.Config{Certificates: []tls.Certificate{newTestCert(t, "logstash.test", newCA).tlsCertificate()}})
+
+	transport, err := newTLSTransport(&routeConfig{
+		tlsCAFile:     filepath.Join(dir, "ca.pem"),
+		tlsServerName: "logstash.test",
+		tlsMinVersion: "1.2",
+	})
+	assert.Nil(err)
+
+	_, err = transport.Dial(addr, nil)
+	assert.NotNil(err)
+
+	// The CA is rotated.
+	newCA.writeFiles(t, dir, "ca")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "ca.pem"), later, later)
+
+	conn, err := transport.Dial(addr, nil)
+	assert.Nil(err)
+	if conn != nil {
+		conn.Close()
+	}
+
+	// A broken file keeps the loaded certificates.
+	ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte("broken"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "ca.pem"), later, later)
+
+	conn, err = transport.Dial(addr, nil)
+	assert.Nil(err)
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func TestNewTLSTransportInvalidFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte("broken"), 0600)
+
+	_, err := newTLSTransport(&routeConfig{tlsCAFile: filepath.Join(dir, "ca.pem")})
+	assert.NotNil(err)
+
+	_, err = newTLSTransport(&routeConfig{tlsCertFile: filepath.Join(dir, "missing.pem"), tlsKeyFile: filepath.Join(dir, "missing.key")})
+	assert.NotNil(err)
+}
+
+func TestParsePin(t *testing.T) {
+	assert := assert.New(t)
+
+	hash := sha256.Sum256([]byte("key"))
+	encoded := base64.StdEncoding.EncodeToString(hash[:])
+
+	for _, s := range []string{encoded, "sha256/" + encoded, " " + encoded + " "} {
+		pin, err := parsePin(s)
+		assert.Nil(err)
+		assert.Equal(hash[:], pin)
+	}
+	for _, s := range []string{"sha256/AAAA", "not base64!", ""} {
+		_, err := parsePin(s)
+		assert.NotNil(err)
+	}
+}
+
+func TestNewTransportKeepsRegisteredTLS(t *testing.T) {
+	assert := assert.New(t)
+
+	registered := &MockTransport{}
+	router.AdapterTransports.Register(registered, "tls")
+	route := &router.Route{Adapter: "logstash+tls"}
+
+	// Without TLS settings the LOGSPOUT_TLS_* settings of the registered
+	// transport apply.
+	config, _ := newRouteConfig(route)
+	transport, err := newTransport(route, "tls", config)
+	assert.Nil(err)
+	assert.Equal(registered, transport)
+
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	newTestCert(t, "ca", nil).writeFiles(t, dir, "ca")
+
+	for name, value := range map[string]string{
+		"tls_ca_file":     filepath.Join(dir, "ca.pem"),
+		"tls_server_name": "logstash.test",
+		"tls_min_version": "1.3",
+	} {
+		route.Options = map[string]string{name: value}
+		config, _ = newRouteConfig(route)
+		transport, err = newTransport(route, "tls", config)
+		assert.Nil(err)
+		_, ok := transport.(*tlsTransport)
+		assert.True(ok, name)
+	}
+}